@@ -3,11 +3,16 @@ package main
 import (
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 
 	webapp "github.com/aichaos/silhouette/webapp"
 	"github.com/aichaos/silhouette/webapp/config"
 	"github.com/aichaos/silhouette/webapp/log"
 	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/models/deletion"
+	"github.com/aichaos/silhouette/webapp/password"
+	"github.com/aichaos/silhouette/webapp/purge"
 	"github.com/aichaos/silhouette/webapp/redis"
 	"github.com/urfave/cli/v2"
 	"gorm.io/driver/postgres"
@@ -105,12 +110,17 @@ func main() {
 							},
 							&cli.BoolFlag{
 								Name:  "admin",
-								Usage: "set admin status",
+								Usage: "grant the superuser role",
 							},
 						},
 						Action: func(c *cli.Context) error {
 							initdb(c)
 
+							// Hold admin-created passwords to the same policy as everyone else's.
+							if err := password.Check("password", c.String("password"), c.String("username"), c.String("email")); err != nil {
+								return fmt.Errorf("password: %s", err)
+							}
+
 							log.Info("Creating user account: %s", c.String("username"))
 							user, err := models.CreateUser(
 								c.String("username"),
@@ -124,13 +134,130 @@ func main() {
 
 							// Making an admin?
 							if c.Bool("admin") {
-								log.Warn("Promoting user to admin status")
-								user.IsAdmin = true
-								user.Save()
+								log.Warn("Granting the %s role to the user", models.RoleSuperuser)
+								return changeRole(user.Username, models.RoleSuperuser, true)
+							}
+							return nil
+						},
+					},
+					{
+						Name:  "hash-report",
+						Usage: "count user accounts by password hashing scheme",
+						Action: func(c *cli.Context) error {
+							initdb(c)
+
+							counts, err := models.CountPasswordSchemes()
+							if err != nil {
+								return err
+							}
+
+							var schemes = []string{}
+							for scheme := range counts {
+								schemes = append(schemes, scheme)
+							}
+							sort.Strings(schemes)
+
+							var current = password.CurrentHashParams.String()
+							fmt.Printf("Current scheme: %s\n\n", current)
+							for _, scheme := range schemes {
+								var note = "rehashed at next login"
+								if scheme == current {
+									note = "current"
+								}
+								fmt.Printf("%8d  %-28s (%s)\n", counts[scheme], scheme, note)
+							}
+							return nil
+						},
+					},
+					{
+						Name:  "purge",
+						Usage: "delete the accounts whose deletion grace period has run out (the web server does this hourly)",
+						Action: func(c *cli.Context) error {
+							initdb(c)
+							initcache(c)
+
+							count, err := purge.Due()
+							if err != nil {
+								return err
 							}
+							log.Info("Deleted %d account(s)", count)
 							return nil
 						},
 					},
+					{
+						Name:  "delete",
+						Usage: "delete a user account and all its data right away",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "username",
+								Aliases:  []string{"u"},
+								Required: true,
+								Usage:    "username or email",
+							},
+							&cli.BoolFlag{
+								Name:  "dry-run",
+								Usage: "list what would be deleted, without deleting it",
+							},
+						},
+						Action: func(c *cli.Context) error {
+							initdb(c)
+							initcache(c)
+
+							return deleteUser(c.String("username"), c.Bool("dry-run"))
+						},
+					},
+				},
+			},
+			{
+				Name:  "role",
+				Usage: "manage the roles that grant admin permissions",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "list",
+						Usage: "list the roles, their permissions and who has them",
+						Action: func(c *cli.Context) error {
+							initdb(c)
+
+							roles, err := models.GetRoles()
+							if err != nil {
+								return err
+							}
+
+							for _, role := range roles {
+								fmt.Printf("%s: %s\n", role.Name, role.Description)
+								fmt.Printf("    Permissions: %s\n", role.PermissionNames())
+
+								users, err := models.GetRoleUsers(role)
+								if err != nil {
+									return err
+								}
+								var names = []string{}
+								for _, user := range users {
+									names = append(names, user.Username)
+								}
+								fmt.Printf("    Users: %s\n\n", strings.Join(names, ", "))
+							}
+							return nil
+						},
+					},
+					{
+						Name:  "grant",
+						Usage: "grant a role to a user",
+						Flags: roleFlags,
+						Action: func(c *cli.Context) error {
+							initdb(c)
+							return changeRole(c.String("username"), c.String("role"), true)
+						},
+					},
+					{
+						Name:  "revoke",
+						Usage: "revoke a role from a user",
+						Flags: roleFlags,
+						Action: func(c *cli.Context) error {
+							initdb(c)
+							return changeRole(c.String("username"), c.String("role"), false)
+						},
+					},
 				},
 			},
 			{
@@ -158,6 +285,104 @@ func main() {
 	}
 }
 
+// Flags for the role grant and revoke commands.
+var roleFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:     "username",
+		Aliases:  []string{"u"},
+		Required: true,
+		Usage:    "username or email",
+	},
+	&cli.StringFlag{
+		Name:     "role",
+		Aliases:  []string{"r"},
+		Required: true,
+		Usage:    "role name, e.g. superuser or moderator",
+	},
+}
+
+// changeRole grants or revokes a user's role from the command line, recording it in the audit log.
+func changeRole(username, roleName string, grant bool) error {
+	user, err := models.FindUser(username)
+	if err != nil {
+		return fmt.Errorf("user %s: %s", username, err)
+	}
+
+	role, err := models.GetRole(roleName)
+	if err != nil {
+		return fmt.Errorf("role %s: %s", roleName, err)
+	}
+
+	before, err := user.Roles()
+	if err != nil {
+		return err
+	}
+
+	if grant {
+		err = user.GrantRole(role)
+	} else {
+		err = user.RevokeRole(role)
+	}
+	if err != nil {
+		return err
+	}
+
+	after, err := user.Roles()
+	if err != nil {
+		return err
+	}
+
+	if models.RoleNames(before) == models.RoleNames(after) {
+		log.Info("No change: %s has roles: %s", user.Username, models.RoleNames(after))
+		return nil
+	}
+
+	log.Info("%s now has roles: %s", user.Username, models.RoleNames(after))
+	return models.CreateAuditLog(&models.AuditLog{
+		TargetID:       user.ID,
+		TargetUsername: user.Username,
+		Action:         models.AuditPromote,
+		Before:         models.RoleNames(before),
+		After:          models.RoleNames(after),
+		Reason:         "command line",
+	})
+}
+
+// deleteUser deletes an account from the command line, recording it in the audit log. A dry run
+// lists what each step of the deletion would remove instead.
+func deleteUser(username string, dryRun bool) error {
+	user, err := models.FindUser(username)
+	if err != nil {
+		return fmt.Errorf("user %s: %s", username, err)
+	}
+
+	if dryRun {
+		report, err := deletion.DryRun(user)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Deleting %s (ID %d) would remove:\n\n", user.Username, user.ID)
+		for _, step := range report {
+			fmt.Printf("%8d  %s\n", step.Count, step.Step)
+		}
+		return nil
+	}
+
+	if err := deletion.DeleteUser(user); err != nil {
+		return err
+	}
+
+	log.Info("Deleted %s", user.Username)
+	return models.CreateAuditLog(&models.AuditLog{
+		TargetID:       user.ID,
+		TargetUsername: user.Username,
+		Action:         models.AuditAdminDelete,
+		Before:         string(user.Status),
+		Reason:         "command line",
+	})
+}
+
 func initdb(c *cli.Context) {
 	// Load the settings.json
 	config.LoadSettings()
@@ -192,7 +417,9 @@ func initdb(c *cli.Context) {
 }
 
 func initcache(c *cli.Context) {
-	// Initialize Redis.
-	log.Info("Initializing Redis")
-	redis.Setup(c.String("redis"))
+	// Initialize Redis (or the cache store configured instead).
+	log.Info("Initializing cache store: %s", config.Current.Redis.Store)
+	if err := redis.Setup(config.Current.Redis); err != nil {
+		log.Fatal("Couldn't set up the cache store: %s", err)
+	}
 }
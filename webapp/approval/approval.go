@@ -0,0 +1,83 @@
+// Package approval holds new accounts for an admin to approve, when the settings require it, and
+// lets the applicant know the decision by email.
+package approval
+
+import (
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/mail"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/models/deletion"
+	"github.com/aichaos/silhouette/webapp/router"
+)
+
+// NewStatus is the status to create new accounts with: pending, if the settings require approval.
+func NewStatus() models.UserStatus {
+	if config.Current.RequireApproval {
+		return models.UserStatusPending
+	}
+	return models.UserStatusActive
+}
+
+// Hold emails the admins about a new account that was created pending approval (see NewStatus).
+func Hold(user *models.User) {
+	if user.Status != models.UserStatusPending {
+		return
+	}
+
+	if config.Current.AdminEmail != "" {
+		if err := mail.Send(mail.Message{
+			To:       config.Current.AdminEmail,
+			Subject:  "New account awaiting approval: " + user.Username,
+			Template: "email/admin_approval.html",
+			Data: map[string]interface{}{
+				"User": user,
+				"URL":  router.MustAbsoluteURLFor("admin.approvals"),
+			},
+		}); err != nil {
+			log.Error("approval.Hold: couldn't email the admins about %s: %s", user.Username, err)
+		}
+	}
+}
+
+// Approve a pending account and email the user that they can log in.
+func Approve(user *models.User) error {
+	user.Status = models.UserStatusActive
+	if err := user.Save(); err != nil {
+		return err
+	}
+
+	if err := mail.Send(mail.Message{
+		To:       user.Email,
+		Subject:  "Your account has been approved",
+		Template: "email/approved.html",
+		Data: map[string]interface{}{
+			"Title":    config.Title,
+			"Username": user.Username,
+			"URL":      router.MustAbsoluteURLFor("login"),
+		},
+	}); err != nil {
+		log.Error("approval.Approve: couldn't email %s: %s", user.Username, err)
+	}
+
+	return nil
+}
+
+// Reject a pending account: email the user the reason and delete the account, so they may apply
+// again.
+func Reject(user *models.User, reason string) error {
+	if err := mail.Send(mail.Message{
+		To:       user.Email,
+		Subject:  "Your account application was not approved",
+		Template: "email/rejected.html",
+		Data: map[string]interface{}{
+			"Title":    config.Title,
+			"Username": user.Username,
+			"Reason":   reason,
+		},
+	}); err != nil {
+		log.Error("approval.Reject: couldn't email %s: %s", user.Username, err)
+	}
+
+	return deletion.DeleteUser(user)
+}
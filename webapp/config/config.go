@@ -19,27 +19,53 @@ const (
 	SettingsPath = "./settings.toml"
 )
 
+// Password hashing. New hashes use PasswordHashScheme ("argon2id" or "bcrypt"); stored hashes
+// from an older scheme or with weaker parameters are rehashed when the user next logs in.
+const (
+	PasswordHashScheme = "argon2id"
+	BcryptCost         = 14
+	Argon2Time         = 3         // iterations
+	Argon2Memory       = 64 * 1024 // KiB
+	Argon2Threads      = 4
+	Argon2KeyLength    = 32
+	Argon2SaltLength   = 16
+)
+
 // Security
 const (
-	BcryptCost            = 14
 	SessionCookieName     = "session_id"
 	CSRFCookieName        = "xsrf_token"
 	CSRFInputName         = "_csrf" // html input name
+	CSRFHeaderName        = "X-CSRF-Token"
 	SessionCookieMaxAge   = 60 * 60 * 24 * 30
 	SessionRedisKeyFormat = "session/%s"
+	SessionIndexRedisKey  = "user-sessions/%d"      // index of session UUIDs by user ID
+	SessionTouchCooldown  = 5 * time.Minute         // how often to refresh LastSeen on a session
 	MultipartMaxMemory    = 1024 * 1024 * 1024 * 20 // 20 MB
 )
 
+// Proof of work (see settings.toml for the difficulty)
+const (
+	ProofOfWorkInputName      = "_pow"       // html input for the challenge
+	ProofOfWorkNonceInputName = "_pow_nonce" // html input for the browser's solution
+	ProofOfWorkRedisKey       = "pow/%s"     // challenges already used
+	ProofOfWorkMaxNonce       = 20           // characters
+)
+
 // Authentication
 const (
 	// Skip the email verification step. The signup page will directly ask for
 	// email+username+password rather than only email and needing verification.
 	SkipEmailVerification = false
 
-	SignupTokenRedisKey   = "signup-token/%s"
-	ResetPasswordRedisKey = "reset-password/%s"
-	ChangeEmailRedisKey   = "change-email/%s"
-	SignupTokenExpires    = 24 * time.Hour // used for all tokens so far
+	SignupTokenRedisKey    = "signup-token/%s"
+	ResetPasswordRedisKey  = "reset-password/%s"
+	ChangeEmailRedisKey    = "change-email/%s"
+	MagicLinkRedisKey      = "magic-link/%s"
+	CancelDeletionRedisKey = "cancel-deletion/%s"
+	UserTokensRedisKey     = "user-tokens/%d" // index of the above by user ID, to clean up on deletion
+	SignupTokenExpires     = 24 * time.Hour   // used for all tokens so far
+	MagicLinkExpires       = 15 * time.Minute
 
 	// Rate limits
 	RateLimitRedisKey        = "rate-limit/%s/%s" // namespace, id
@@ -47,9 +73,61 @@ const (
 	LoginRateLimit           = 10 // 10 failed login attempts = locked for full hour
 	LoginRateLimitCooldownAt = 3  // 3 failed attempts = start throttling
 	LoginRateLimitCooldown   = 30 * time.Second
+	MagicLinkRateLimitWindow = 1 * time.Hour
+	MagicLinkRateLimit       = 5 // per email address and per IP address
 
 	// How frequently to refresh LastLoginAt since sessions are long-lived.
 	LastLoginAtCooldown = 8 * time.Hour
+
+	// How long an admin may impersonate a user before being switched back.
+	ImpersonateExpires = 1 * time.Hour
+
+	// How often the web server purges accounts whose deletion grace period has run out.
+	DeletionPurgeInterval = 1 * time.Hour
+)
+
+// Personal data export
+const (
+	DataExportRedisKey        = "data-export/%s"
+	DataExportExpires         = 24 * time.Hour // how long the emailed download link works
+	DataExportRateLimit       = 3              // exports per user per window
+	DataExportRateLimitWindow = 24 * time.Hour
+)
+
+// Personal API tokens
+const (
+	APITokenPrefix = "wat_" // makes tokens recognizable (e.g. to secret scanners)
+	APITokenSize   = 32     // bytes of entropy
+)
+
+// Invite codes
+const (
+	InviteCodeLength   = 10                                 // characters
+	InviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789" // no look-alikes such as 0/O and 1/I
+)
+
+// OpenID Connect login
+const (
+	OIDCStateRedisKey  = "oidc-state/%s"
+	OIDCSignupRedisKey = "oidc-signup/%s"
+	OIDCStateExpires   = 10 * time.Minute
+	OIDCClockSkew      = 1 * time.Minute
+)
+
+// Two-factor authentication (TOTP)
+const (
+	TwoFactorSecretSize    = 20 // bytes of entropy in the shared secret
+	TwoFactorDigits        = 6
+	TwoFactorPeriod        = 30 * time.Second
+	TwoFactorSkew          = 1 // accept codes this many periods before/after now
+	TwoFactorLoginExpires  = 5 * time.Minute
+	TwoFactorRecoveryCodes = 10 // number of recovery codes to issue
+
+	// Rate limit for failed codes at login.
+	TwoFactorRateLimitWindow     = 1 * time.Hour
+	TwoFactorRateLimit           = 10
+	TwoFactorRateLimitCooldownAt = 3
+	TwoFactorRateLimitCooldown   = 30 * time.Second
 )
 
 var (
@@ -61,6 +139,7 @@ var (
 		"moderator",
 		"support",
 		"staff",
+		"me", // /v1/users/me is the current user
 	}
 )
 
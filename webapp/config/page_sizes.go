@@ -9,5 +9,11 @@ const (
 
 // Pagination sizes per page.
 var (
-	PageSizeMemberSearch = 60
+	PageSizeMemberSearch   = 60
+	PageSizeAuditLog       = 50
+	PageSizeAdminUsers     = 50
+	PageSizeAdminUserAudit = 20 // recent audit log entries on the admin user detail page
+	PageSizeAdminInvites   = 50
+	PageSizeAdminApprovals = 20
+	PageSizeAdminDeletions = 20
 )
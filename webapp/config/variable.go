@@ -2,9 +2,12 @@ package config
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/aichaos/silhouette/webapp/log"
@@ -21,6 +24,13 @@ type Variable struct {
 	Redis            Redis
 	Database         Database
 	UseXForwardedFor bool
+	RequireApproval  bool // new accounts wait for an admin to approve them
+	OIDC             []OIDCProvider
+	PasswordPolicy   PasswordPolicy
+	RateLimit        []RateLimitRule
+	Invites          Invites
+	ProofOfWork      ProofOfWork
+	Deletion         Deletion
 }
 
 // DefaultVariable returns the default settings.toml data.
@@ -34,13 +44,38 @@ func DefaultVariable() Variable {
 			From:    "no-reply@localhost",
 		},
 		Redis: Redis{
-			Host: "localhost",
-			Port: 6379,
+			Store:  "redis",
+			Host:   "localhost",
+			Port:   6379,
+			SQLite: "cache.sqlite",
 		},
 		Database: Database{
 			SQLite:   "database.sqlite",
 			Postgres: "host=localhost user=webapp password=webapp dbname=webapp port=5679 sslmode=disable TimeZone=America/Los_Angeles",
 		},
+		PasswordPolicy: PasswordPolicy{
+			MinLength:      8,
+			RejectUserInfo: true,
+			MinStrength:    2,
+		},
+		RateLimit: []RateLimitRule{
+			{Name: "login", By: "ip", Methods: []string{"POST"}, Limit: 30, Window: 15 * time.Minute},
+			{Name: "signup", By: "ip", Methods: []string{"POST"}, Limit: 10, Window: time.Hour},
+			{Name: "forgot-password", By: "ip", Methods: []string{"POST"}, Limit: 10, Window: time.Hour},
+			{Name: "api", By: "token", Limit: 300, Window: 5 * time.Minute},
+		},
+		Invites: Invites{
+			MemberLimit:   5,
+			MemberExpires: 7 * 24 * time.Hour,
+		},
+		Deletion: Deletion{
+			GraceDays: 14,
+		},
+		ProofOfWork: ProofOfWork{
+			Difficulty: 18,
+			Secret:     randomSecret(),
+			Expires:    10 * time.Minute,
+		},
 	}
 }
 
@@ -53,22 +88,36 @@ func LoadSettings() {
 			panic(fmt.Sprintf("LoadSettings: couldn't read settings.toml: %s", err))
 		}
 
-		var v Variable
-		err = toml.Unmarshal(content, &v)
+		// Start from the defaults so sections missing from an older settings.toml keep them. Lists
+		// are decoded over the defaults by position, so the rate limit rules start empty and get the
+		// defaults back only if the file has none.
+		var v = DefaultVariable()
+		v.RateLimit = nil
+		md, err := toml.Decode(string(content), &v)
 		if err != nil {
 			panic(fmt.Sprintf("LoadSettings: couldn't parse settings.toml: %s", err))
 		}
+		if !md.IsDefined("RateLimit") {
+			v.RateLimit = DefaultVariable().RateLimit
+		}
+
+		// A settings.toml from before proof of work has no secret for it. It must stay the same
+		// across restarts and between app servers, so make one now and save it.
+		if !md.IsDefined("ProofOfWork", "Secret") || v.ProofOfWork.Secret == "" {
+			v.ProofOfWork.Secret = randomSecret()
+			if err := writeSettings(v); err != nil {
+				log.Error("LoadSettings: couldn't save a new ProofOfWork secret to settings.toml, so proof of work is off: %s", err)
+				v.ProofOfWork.Difficulty = 0
+			} else {
+				log.Warn("NOTICE: Added a new ProofOfWork secret to settings.toml - copy it to your other app servers!")
+			}
+		}
 
 		Current = v
 	} else {
-		var buf bytes.Buffer
-		enc := toml.NewEncoder(&buf)
-		err := enc.Encode(DefaultVariable())
-		if err != nil {
-			panic(fmt.Sprintf("LoadSettings: couldn't marshal default settings: %s", err))
+		if err := writeSettings(DefaultVariable()); err != nil {
+			log.Error("LoadSettings: couldn't write the default settings.toml: %s", err)
 		}
-
-		ioutil.WriteFile(SettingsPath, buf.Bytes(), 0600)
 		log.Warn("NOTICE: Created default settings.toml file - review it and configure mail servers and database!")
 	}
 
@@ -79,6 +128,16 @@ func LoadSettings() {
 	}
 }
 
+// writeSettings saves the settings to settings.toml.
+func writeSettings(v Variable) error {
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(SettingsPath, buf.Bytes(), 0600)
+}
+
 // Mail settings.
 type Mail struct {
 	Enabled  bool
@@ -91,9 +150,88 @@ type Mail struct {
 
 // Redis settings.
 type Redis struct {
-	Host string
-	Port int
-	DB   int
+	Store  string // "redis" (default), "memory" (one process, lost on restart) or "sqlite" (one node)
+	Host   string
+	Port   int
+	DB     int
+	SQLite string // database file for the sqlite store
+}
+
+// OIDCProvider settings for "log in with" an OpenID Connect identity provider.
+type OIDCProvider struct {
+	Name         string // short name used in URLs, e.g. "google"
+	Label        string // display name for the login button, e.g. "Google"
+	Issuer       string // e.g. https://accounts.google.com
+	ClientID     string
+	ClientSecret string
+	Scopes       []string // default: openid, email, profile
+}
+
+// PasswordPolicy settings for new passwords chosen at signup, password reset or change.
+type PasswordPolicy struct {
+	MinLength      int  // 8
+	RequireLower   bool // require at least one of each character class
+	RequireUpper   bool
+	RequireDigit   bool
+	RequireSymbol  bool
+	RejectUserInfo bool // reject passwords containing the username or email
+	MinStrength    int  // estimated strength from 0 (weakest) to 4; 2
+
+	// Path to a local breached password file: SHA-1 hashes (hex, one per line, sorted) such as
+	// the "ordered by hash" download from Have I Been Pwned. Lines may carry a ":count" suffix.
+	BreachedHashFile string
+}
+
+// RateLimitRule settings for a group of routes wrapped by middleware.RateLimit in the router.
+type RateLimitRule struct {
+	Name    string        // the router refers to rules by name, e.g. "login"
+	By      string        // key requests by "ip", "user" (or IP when logged out), or "token" (or user, or IP)
+	Methods []string      // HTTP methods to count, e.g. ["POST"]; default all
+	Limit   int           // requests allowed per window
+	Window  time.Duration // e.g. "15m" in settings.toml
+
+	Algorithm  string // "fixed-window" (default), "sliding-window" or "gcra"
+	FailClosed bool   // refuse requests if Redis is down (default: let them through)
+}
+
+// GetRateLimitRule finds a rate limit rule by name.
+func (v Variable) GetRateLimitRule(name string) (RateLimitRule, bool) {
+	for _, rule := range v.RateLimit {
+		if rule.Name == name {
+			return rule, true
+		}
+	}
+	return RateLimitRule{}, false
+}
+
+// Invites settings for invitation-only signup.
+type Invites struct {
+	Required         bool          // new accounts need an invite code to sign up
+	MembersCanInvite bool          // members may invite people, not only staff with the users.invite permission
+	MemberLimit      int           // unused invites a member may have at once; 5
+	MemberExpires    time.Duration // how long a member's invite lasts; "168h"
+}
+
+// ProofOfWork settings for the puzzle browsers solve before submitting the signup and forgot
+// password forms (see pkg/pow).
+type ProofOfWork struct {
+	Difficulty int           // leading zero bits the solution's hash needs; 18 (0 to disable)
+	Secret     string        // HMAC key that signs the challenges; made and saved at startup if not set
+	Expires    time.Duration // how long a challenge may be solved for; "10m"
+}
+
+// Deletion settings for accounts their owners ask to delete.
+type Deletion struct {
+	GraceDays int // days until the account is purged, while the user may cancel; 14 (0 to delete at once)
+}
+
+// randomSecret makes a new random key, e.g. for a default ProofOfWork secret.
+func randomSecret() string {
+	var buf = make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		panic(fmt.Sprintf("randomSecret: %s", err))
+	}
+	return hex.EncodeToString(buf)
 }
 
 // Database settings.
@@ -0,0 +1,97 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+)
+
+// loadSettings runs LoadSettings on a settings.toml with the given content.
+func loadSettings(t *testing.T, content string) {
+	t.Helper()
+	inTempDir(t, func() {
+		if err := ioutil.WriteFile(config.SettingsPath, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		config.LoadSettings()
+	})
+}
+
+// inTempDir runs a function in a new temporary directory, where settings.toml will be.
+func inTempDir(t *testing.T, fn func()) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	fn()
+}
+
+func TestLoadRateLimitRules(t *testing.T) {
+	// A custom rule doesn't pick up the fields of the default rule at the same position.
+	loadSettings(t, `
+[Database]
+  IsSQLite = true
+
+[[RateLimit]]
+  Name = "api"
+  By = "token"
+  Limit = 100
+  Window = "1m"
+`)
+
+	var expect = []config.RateLimitRule{
+		{Name: "api", By: "token", Limit: 100, Window: time.Minute},
+	}
+	if !reflect.DeepEqual(config.Current.RateLimit, expect) {
+		t.Errorf("custom rules: expected %+v but got %+v", expect, config.Current.RateLimit)
+	}
+
+	// With no rules, the defaults are kept.
+	loadSettings(t, `
+[Database]
+  IsSQLite = true
+`)
+
+	if defaults := config.DefaultVariable().RateLimit; !reflect.DeepEqual(config.Current.RateLimit, defaults) {
+		t.Errorf("no rules: expected the defaults %+v but got %+v", defaults, config.Current.RateLimit)
+	}
+}
+
+func TestProofOfWorkSecret(t *testing.T) {
+	inTempDir(t, func() {
+		// An older settings.toml without a secret gets one saved into it.
+		var content = `
+[Database]
+  IsSQLite = true
+
+[ProofOfWork]
+  Difficulty = 18
+`
+		if err := ioutil.WriteFile(config.SettingsPath, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		config.LoadSettings()
+		var secret = config.Current.ProofOfWork.Secret
+		if secret == "" || config.Current.ProofOfWork.Difficulty != 18 {
+			t.Fatalf("expected a new secret with proof of work on but got %+v", config.Current.ProofOfWork)
+		}
+
+		// The same one is used next time.
+		config.LoadSettings()
+		if config.Current.ProofOfWork.Secret != secret {
+			t.Errorf("expected the saved secret %s but got %s", secret, config.Current.ProofOfWork.Secret)
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package account
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+// APITokens page to create and revoke personal API tokens (/settings/api-tokens).
+func APITokens() http.HandlerFunc {
+	tmpl := templates.Must("account/api_tokens.html")
+
+	// Whitelist for expiration options (in days; 0 = never).
+	var expiresWhitelist = []int{30, 90, 365, 0}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var vars = map[string]interface{}{
+			"Scopes":         models.APIScopes,
+			"ExpiresOptions": expiresWhitelist,
+		}
+
+		currentUser, err := session.CurrentUser(r)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't get CurrentUser: %s", err)
+			templates.Redirect(w, "/")
+			return
+		}
+
+		// Are we POSTing?
+		if r.Method == http.MethodPost {
+			intent := r.PostFormValue("intent")
+			switch intent {
+			case "create":
+				var (
+					name      = r.PostFormValue("name")
+					scopes    = r.PostForm["scope"]
+					days, _   = strconv.Atoi(r.PostFormValue("expires"))
+					daysOK    bool
+					expiresAt time.Time
+				)
+
+				for _, v := range expiresWhitelist {
+					if days == v {
+						daysOK = true
+						break
+					}
+				}
+				if !daysOK {
+					session.FlashError(w, r, "Invalid expiration option.")
+					templates.Redirect(w, r.URL.Path)
+					return
+				}
+				if days > 0 {
+					expiresAt = time.Now().Add(time.Duration(days) * 24 * time.Hour)
+				}
+
+				token, plaintext, err := models.CreateAPIToken(currentUser, name, scopes, expiresAt)
+				if err != nil {
+					session.FlashError(w, r, "Couldn't create the API token: %s", err)
+					templates.Redirect(w, r.URL.Path)
+					return
+				}
+
+				// Show the new token to the user (only this once).
+				session.Flash(w, r, "Your new API token \"%s\" has been created.", token.Name)
+				vars["NewToken"] = plaintext
+			case "revoke":
+				tokenID, err := strconv.Atoi(r.PostFormValue("token_id"))
+				if err != nil {
+					session.FlashError(w, r, "Invalid token ID.")
+				} else if token, err := models.GetAPIToken(currentUser.ID, uint64(tokenID)); err != nil {
+					session.FlashError(w, r, "API token not found.")
+				} else if err := token.Delete(); err != nil {
+					session.FlashError(w, r, "Couldn't revoke the API token: %s", err)
+				} else {
+					session.Flash(w, r, "The API token \"%s\" has been revoked.", token.Name)
+				}
+				templates.Redirect(w, r.URL.Path)
+				return
+			default:
+				session.FlashError(w, r, "Unknown POST intent value. Please try again.")
+				templates.Redirect(w, r.URL.Path)
+				return
+			}
+		}
+
+		tokens, err := models.GetAPITokens(currentUser.ID)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't list your API tokens: %s", err)
+		}
+		vars["Tokens"] = tokens
+
+		if err := tmpl.Execute(w, r, vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
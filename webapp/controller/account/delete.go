@@ -4,7 +4,13 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/models"
 	"github.com/aichaos/silhouette/webapp/models/deletion"
+	"github.com/aichaos/silhouette/webapp/purge"
+	"github.com/aichaos/silhouette/webapp/ratelimit"
+	"github.com/aichaos/silhouette/webapp/router"
 	"github.com/aichaos/silhouette/webapp/session"
 	"github.com/aichaos/silhouette/webapp/templates"
 )
@@ -29,8 +35,10 @@ func Delete() http.HandlerFunc {
 				return
 			}
 
-			// Delete their account!
-			if err := deletion.DeleteUser(currentUser); err != nil {
+			// Delete their account, after the grace period if there is one.
+			var oldStatus = currentUser.Status
+			d, err := purge.Request(currentUser)
+			if err != nil {
 				session.FlashError(w, r, "Error while deleting your account: %s", err)
 				templates.Redirect(w, r.URL.Path)
 				return
@@ -38,15 +46,87 @@ func Delete() http.HandlerFunc {
 
 			// Sign them out.
 			session.LogoutUser(w, r)
-			session.Flash(w, r, "Your account has been deleted.")
+			if d == nil {
+				session.Audit(r, currentUser, models.AuditLog{Action: models.AuditDelete})
+				session.Flash(w, r, "Your account has been deleted.")
+			} else {
+				session.Audit(r, currentUser, models.AuditLog{
+					Action: models.AuditDeletionRequest,
+					Before: string(oldStatus),
+					After:  string(currentUser.Status) + " until " + purge.Date(d),
+				})
+				session.Flash(w, r, "Your account will be deleted on %s. We have e-mailed you a link to cancel, should you change your mind before then.", purge.Date(d))
+			}
 			templates.Redirect(w, "/")
 			return
 		}
 
-		var vars = map[string]interface{}{}
+		var vars = map[string]interface{}{
+			"GraceDays": config.Current.Deletion.GraceDays,
+		}
 		if err := tmpl.Execute(w, r, vars); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 	})
 }
+
+// CancelDeletion link (/account/cancel-deletion?token=): emailed to users who asked to delete
+// their account, to undo it during the grace period.
+func CancelDeletion() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := purge.GetCancelToken(r.FormValue("token"))
+		if err != nil {
+			session.FlashError(w, r, "Couldn't cancel the deletion of your account: %s.", err)
+			templates.Redirect(w, "/")
+			return
+		}
+
+		user, err := models.GetUser(token.UserID)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't cancel the deletion of your account: it has already been deleted.")
+			templates.Redirect(w, "/")
+			return
+		}
+
+		if err := purge.Cancel(user); err != nil {
+			session.FlashError(w, r, "Couldn't cancel the deletion of your account: %s.", err)
+			templates.Redirect(w, "/")
+			return
+		}
+		session.Audit(r, user, models.AuditLog{
+			Action: models.AuditDeletionCancel,
+			Before: models.UserStatusPendingDeletion,
+			After:  string(user.Status),
+		})
+
+		if err := token.Delete(); err != nil {
+			log.Error("CancelDeletion: couldn't delete token for %s: %s", user.Username, err)
+		}
+
+		session.Flash(w, r, "Your account will not be deleted after all. You may log in again.")
+		templates.Redirect(w, router.MustURLFor("login"))
+	})
+}
+
+// accountRateLimits are this package's limiters for a deleted user.
+func accountRateLimits(user *models.User) []*ratelimit.Limiter {
+	return []*ratelimit.Limiter{
+		{Namespace: "login", ID: user.ID},
+		{Namespace: "2fa", ID: user.ID},
+		{Namespace: "magic-link-email", ID: strings.ToLower(user.Email)},
+		{Namespace: "data-export", ID: user.ID},
+	}
+}
+
+func init() {
+	deletion.RegisterCache(deletion.CacheStep{
+		Name: "Account rate limits",
+		Count: func(user *models.User) (int64, error) {
+			return ratelimit.CountActive(accountRateLimits(user)), nil
+		},
+		Delete: func(user *models.User) error {
+			return ratelimit.ClearAll(accountRateLimits(user))
+		},
+	})
+}
@@ -0,0 +1,124 @@
+package account
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/mail"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/models/export"
+	"github.com/aichaos/silhouette/webapp/ratelimit"
+	"github.com/aichaos/silhouette/webapp/redis"
+	"github.com/aichaos/silhouette/webapp/router"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+	"github.com/aichaos/silhouette/webapp/utility"
+)
+
+// ExportToken goes in Redis with a user's data export, for the emailed download link.
+type ExportToken struct {
+	Token     string
+	UserID    uint64
+	CreatedAt time.Time
+	Data      []byte // the ZIP file
+}
+
+// GetExportToken looks up the token from a download link.
+func GetExportToken(token string) (ExportToken, error) {
+	var t ExportToken
+	if err := redis.Get(fmt.Sprintf(config.DataExportRedisKey, token), &t); err != nil || t.Token != token {
+		return t, errors.New("invalid or expired link")
+	}
+	return t, nil
+}
+
+// Export the user's data (/settings/export): POST to have a ZIP of it built and a download link
+// emailed to them, then GET with the link's token to download it.
+func Export() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currentUser, err := session.CurrentUser(r)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't get CurrentUser: %s", err)
+			templates.Redirect(w, "/")
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			limiter := &ratelimit.Limiter{
+				Namespace: "data-export",
+				ID:        currentUser.ID,
+				Limit:     config.DataExportRateLimit,
+				Window:    config.DataExportRateLimitWindow,
+			}
+			if err := limiter.Ping(); err != nil {
+				session.FlashError(w, r, err.Error())
+				templates.Redirect(w, "/settings#export")
+				return
+			}
+
+			session.Audit(r, currentUser, models.AuditLog{
+				Action: models.AuditDataExport,
+			})
+
+			go sendExport(currentUser)
+
+			session.Flash(w, r, "We are gathering your data, and will e-mail a download link to %s when it's ready.", currentUser.Email)
+			templates.Redirect(w, "/settings#export")
+			return
+		}
+
+		// Download the export from the emailed link.
+		token, err := GetExportToken(r.FormValue("token"))
+		if err != nil || token.UserID != currentUser.ID {
+			session.FlashError(w, r, "Download link is invalid or has expired. Please request your data again.")
+			templates.Redirect(w, "/settings#export")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(
+			`attachment; filename="%s-data-%s.zip"`,
+			currentUser.Username, token.CreatedAt.Format("2006-01-02"),
+		))
+		w.Write(token.Data)
+	})
+}
+
+// sendExport builds a user's data export in the background and emails them the download link.
+func sendExport(user *models.User) {
+	data, err := export.Build(user)
+	if err != nil {
+		log.Error("sendExport(%s): %s", user.Username, err)
+		return
+	}
+
+	token := ExportToken{
+		Token:     uuid.New().String(),
+		UserID:    user.ID,
+		CreatedAt: time.Now(),
+		Data:      data,
+	}
+	if err := redis.SetUserToken(user.ID, fmt.Sprintf(config.DataExportRedisKey, token.Token), token, config.DataExportExpires); err != nil {
+		log.Error("sendExport(%s): couldn't store the export: %s", user.Username, err)
+		return
+	}
+
+	if err := mail.Send(mail.Message{
+		To:       user.Email,
+		Subject:  "Your data is ready to download",
+		Template: "email/data_export.html",
+		Data: map[string]interface{}{
+			"Title":    config.Title,
+			"Username": user.Username,
+			"URL":      router.MustAbsoluteURLFor("settings.export", "token", token.Token),
+			"Expires":  utility.FormatDurationCoarse(config.DataExportExpires),
+		},
+	}); err != nil {
+		log.Error("sendExport(%s): couldn't email the link: %s", user.Username, err)
+	}
+}
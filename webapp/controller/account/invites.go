@@ -0,0 +1,89 @@
+package account
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/invites"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+// Invites page for members to invite their friends (/settings/invites).
+func Invites() http.HandlerFunc {
+	tmpl := templates.Must("account/invites.html")
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currentUser, err := session.CurrentUser(r)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't get CurrentUser: %s", err)
+			templates.Redirect(w, "/")
+			return
+		}
+
+		if !invites.CanInvite(currentUser) {
+			templates.ForbiddenPage.ServeHTTP(w, r)
+			return
+		}
+
+		// Are we POSTing?
+		if r.Method == http.MethodPost {
+			intent := r.PostFormValue("intent")
+			switch intent {
+			case "create":
+				var (
+					email     = strings.TrimSpace(r.PostFormValue("email"))
+					expiresAt time.Time
+				)
+				if config.Current.Invites.MemberExpires > 0 {
+					expiresAt = time.Now().Add(config.Current.Invites.MemberExpires)
+				}
+
+				invite, err := invites.Create(currentUser, 1, expiresAt, email)
+				if err != nil && invite == nil {
+					session.FlashError(w, r, "Couldn't create the invite: %s", err)
+				} else if err != nil {
+					session.FlashError(w, r, "Your invite code is %s, but it couldn't be emailed: %s", invite.Code, err)
+				} else if invite.Email != "" {
+					session.Flash(w, r, "Your invite code %s has been sent to %s.", invite.Code, invite.Email)
+				} else {
+					session.Flash(w, r, "Your invite code is %s.", invite.Code)
+				}
+			case "revoke":
+				inviteID, err := strconv.Atoi(r.PostFormValue("invite_id"))
+				if err != nil {
+					session.FlashError(w, r, "Invalid invite ID.")
+				} else if invite, err := models.GetInvite(uint64(inviteID)); err != nil || invite.UserID != currentUser.ID {
+					session.FlashError(w, r, "Invite not found.")
+				} else if err := invite.Delete(); err != nil {
+					session.FlashError(w, r, "Couldn't revoke the invite: %s", err)
+				} else {
+					session.Flash(w, r, "The invite code %s has been revoked.", invite.Code)
+				}
+			default:
+				session.FlashError(w, r, "Unknown POST intent value. Please try again.")
+			}
+			templates.Redirect(w, r.URL.Path)
+			return
+		}
+
+		userInvites, err := models.GetUserInvites(currentUser.ID)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't list your invites: %s", err)
+		}
+
+		var vars = map[string]interface{}{
+			"Invites":     userInvites,
+			"MemberLimit": config.Current.Invites.MemberLimit,
+			"IsStaff":     currentUser.HasPermission(models.PermissionUsersInvite),
+		}
+		if err := tmpl.Execute(w, r, vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
@@ -1,14 +1,19 @@
 package account
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 
 	"github.com/aichaos/silhouette/webapp/config"
 	"github.com/aichaos/silhouette/webapp/log"
 	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/oidc"
+	"github.com/aichaos/silhouette/webapp/purge"
 	"github.com/aichaos/silhouette/webapp/ratelimit"
+	"github.com/aichaos/silhouette/webapp/router"
 	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/suspension"
 	"github.com/aichaos/silhouette/webapp/templates"
 )
 
@@ -57,33 +62,23 @@ func Login() http.HandlerFunc {
 				return
 			}
 
-			// Is their account banned or disabled?
-			if user.Status != models.UserStatusActive {
-				session.FlashError(w, r, "Your account has been %s. If you believe this was done in error, please contact support.", user.Status)
-				templates.Redirect(w, r.URL.Path)
-				return
-			}
-
-			// OK. Log in the user's session.
-			session.LoginUser(w, r, user)
-
 			// Clear their rate limiter.
 			if err := limiter.Clear(); err != nil {
 				log.Error("Failed to clear login rate limiter: %s", err)
 			}
 
-			// Redirect to their dashboard.
-			session.Flash(w, r, "Login successful.")
-			if strings.HasPrefix(next, "/") {
-				templates.Redirect(w, next)
-			} else {
-				templates.Redirect(w, "/me")
+			// Upgrade their password hash if our settings have moved on since it was made.
+			if err := user.RehashPassword(password); err != nil {
+				log.Error("Failed to rehash password for %s: %s", user.Username, err)
 			}
+
+			finishLogin(w, r, user, next)
 			return
 		}
 
 		var vars = map[string]interface{}{
-			"Next": next,
+			"Next":          next,
+			"OIDCProviders": oidc.Providers(),
 		}
 		if err := tmpl.Execute(w, r, vars); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -92,6 +87,69 @@ func Login() http.HandlerFunc {
 	})
 }
 
+// finishLogin logs in a user whose identity has been verified (by password, identity provider or
+// magic link), applying the account status and two-factor checks, and redirects them onward.
+func finishLogin(w http.ResponseWriter, r *http.Request, user *models.User, next string) {
+	// Are they suspended? (This reinstates them if it has run out.)
+	if s, err := suspension.Check(user); err != nil {
+		log.Error("finishLogin: couldn't check suspension of %s: %s", user.Username, err)
+	} else if s != nil {
+		session.FlashError(w, r, suspension.Message(s))
+		templates.Redirect(w, "/login")
+		return
+	}
+
+	// Is their account banned or disabled?
+	if !user.CanLogIn() {
+		session.FlashError(w, r, loginRefusedMessage(user))
+		templates.Redirect(w, "/login")
+		return
+	}
+
+	// Do they need to enter a two-factor code first?
+	if user.HasTwoFactor() {
+		if err := session.BeginTwoFactor(w, r, user); err != nil {
+			session.FlashError(w, r, "Couldn't begin two-factor login: %s", err)
+			templates.Redirect(w, "/login")
+			return
+		}
+		templates.Redirect(w, router.MustURLFor("login.2fa", "next", next))
+		return
+	}
+
+	// OK. Log in the user's session.
+	session.LoginUser(w, r, user)
+	redirectAfterLogin(w, r, user, next)
+}
+
+// loginRefusedMessage tells a user why their account's status keeps them from logging in.
+func loginRefusedMessage(user *models.User) string {
+	if user.Status == models.UserStatusPendingDeletion {
+		var when = "soon"
+		if d, err := models.GetDeletionRequest(user.ID); err == nil {
+			when = "on " + purge.Date(d)
+		}
+		return fmt.Sprintf("Your account is closed and will be deleted %s. To keep it, use the link we e-mailed you to cancel the deletion.", when)
+	}
+	return fmt.Sprintf("Your account has been %s. If you believe this was done in error, please contact support.", user.Status)
+}
+
+// redirectAfterLogin sends a user who just logged in on to the next page, or their dashboard. A
+// deactivated account is first offered to be reactivated.
+func redirectAfterLogin(w http.ResponseWriter, r *http.Request, user *models.User, next string) {
+	if !strings.HasPrefix(next, "/") {
+		next = "/me"
+	}
+
+	if user.Status == models.UserStatusDeactivated {
+		templates.Redirect(w, router.MustURLFor("account.reactivate", "next", next))
+		return
+	}
+
+	session.Flash(w, r, "Login successful.")
+	templates.Redirect(w, next)
+}
+
 // Logout controller.
 func Logout() http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,169 @@
+package account
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/mail"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/ratelimit"
+	"github.com/aichaos/silhouette/webapp/redis"
+	"github.com/aichaos/silhouette/webapp/router"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+	"github.com/google/uuid"
+)
+
+// MagicLinkToken goes in Redis when a user asks to be emailed a sign-in link.
+type MagicLinkToken struct {
+	Token  string
+	UserID uint64
+	Next   string
+}
+
+// Delete the token.
+func (t MagicLinkToken) Delete() error {
+	return redis.Delete(fmt.Sprintf(config.MagicLinkRedisKey, t.Token))
+}
+
+// MagicLink controller for passwordless login by email (/login/magic).
+//
+// POST with an email address to be sent a link; the link (GET with a token) asks the user to
+// confirm, and POST with the token logs them in. The confirmation step keeps email link scanners
+// from using up the token.
+func MagicLink() http.HandlerFunc {
+	tmpl := templates.Must("account/magic_link.html")
+
+	vagueSuccessMessage := "If that email address is registered, we have sent it a link to sign in. " +
+		"Please check your email inbox; the link expires in " + fmt.Sprintf("%d", int(config.MagicLinkExpires.Minutes())) + " minutes."
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			tokenStr = r.FormValue("token") // GET or POST
+			token    MagicLinkToken
+			user     *models.User
+		)
+
+		// If given a token, validate it first.
+		if tokenStr != "" {
+			if err := redis.Get(fmt.Sprintf(config.MagicLinkRedisKey, tokenStr), &token); err != nil || token.Token != tokenStr {
+				session.FlashError(w, r, "That sign-in link is invalid or has expired. Please request a new one.")
+				templates.Redirect(w, "/login")
+				return
+			}
+
+			target, err := models.GetUser(token.UserID)
+			if err != nil {
+				session.FlashError(w, r, "Couldn't look up the user for this sign-in link. Please try again.")
+				templates.Redirect(w, "/login")
+				return
+			}
+			user = target
+		}
+
+		// Posting?
+		if r.Method == http.MethodPost {
+			// With a validated token: log them in.
+			if user != nil {
+				// Single use: burn the token.
+				if err := token.Delete(); err != nil {
+					log.Error("MagicLinkToken.Delete(%s): %s", token.Token, err)
+				}
+
+				finishLogin(w, r, user, token.Next)
+				return
+			}
+
+			var (
+				email = strings.TrimSpace(strings.ToLower(r.PostFormValue("email")))
+				next  = r.PostFormValue("next")
+			)
+
+			if email == "" || !strings.ContainsRune(email, '@') {
+				session.FlashError(w, r, "Please enter your email address.")
+				templates.Redirect(w, "/login")
+				return
+			}
+
+			// Rate limit by email address and by IP address.
+			for _, limiter := range []*ratelimit.Limiter{
+				{
+					Namespace: "magic-link-email",
+					ID:        email,
+					Limit:     config.MagicLinkRateLimit,
+					Window:    config.MagicLinkRateLimitWindow,
+				},
+				{
+					Namespace: "magic-link-ip",
+					ID:        session.RemoteAddr(r),
+					Limit:     config.MagicLinkRateLimit,
+					Window:    config.MagicLinkRateLimitWindow,
+				},
+			} {
+				if err := limiter.Ping(); err != nil {
+					session.FlashError(w, r, err.Error())
+					templates.Redirect(w, "/login")
+					return
+				}
+			}
+
+			// Look up their account; be vague if not found.
+			target, err := models.FindUser(email)
+			if err != nil {
+				session.Flash(w, r, vagueSuccessMessage)
+				templates.Redirect(w, "/login")
+				return
+			}
+
+			// Create a sign-in token.
+			token := MagicLinkToken{
+				Token:  uuid.New().String(),
+				UserID: target.ID,
+			}
+			if strings.HasPrefix(next, "/") {
+				token.Next = next
+			}
+			if err := redis.SetUserToken(target.ID, fmt.Sprintf(config.MagicLinkRedisKey, token.Token), token, config.MagicLinkExpires); err != nil {
+				session.FlashError(w, r, "Couldn't create a sign-in link: %s", err)
+				templates.Redirect(w, "/login")
+				return
+			}
+
+			// Email them the link.
+			if err := mail.Send(mail.Message{
+				To:       target.Email,
+				Subject:  "Your sign-in link",
+				Template: "email/magic_link.html",
+				Data: map[string]interface{}{
+					"Username": target.Username,
+					"URL":      router.MustAbsoluteURLFor("login.magic", "token", token.Token),
+					"Expires":  fmt.Sprintf("%d minutes", int(config.MagicLinkExpires.Minutes())),
+				},
+			}); err != nil {
+				session.FlashError(w, r, "Error sending an email: %s", err)
+			}
+
+			session.Flash(w, r, vagueSuccessMessage)
+			templates.Redirect(w, "/login")
+			return
+		}
+
+		// A GET without a token has nothing to show.
+		if user == nil {
+			templates.Redirect(w, "/login")
+			return
+		}
+
+		var vars = map[string]interface{}{
+			"Token": token,
+			"User":  user,
+		}
+		if err := tmpl.Execute(w, r, vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
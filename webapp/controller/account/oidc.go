@@ -0,0 +1,358 @@
+package account
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/oidc"
+	"github.com/aichaos/silhouette/webapp/redis"
+	"github.com/aichaos/silhouette/webapp/router"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+// OIDCState goes in Redis while the user is away logging in at the identity provider.
+type OIDCState struct {
+	State       string
+	SessionUUID string // the browser session that began the login
+	Provider    string
+	Nonce       string
+	Verifier    string // PKCE code verifier
+	Next        string
+	LinkUserID  uint64 // linking to an existing account from /settings
+}
+
+// Delete the state when it's been used up.
+func (s OIDCState) Delete() error {
+	return redis.Delete(fmt.Sprintf(config.OIDCStateRedisKey, s.State))
+}
+
+// OIDCSignupToken goes in Redis when a new user logs in with a provider and needs to pick a
+// username for their account.
+type OIDCSignupToken struct {
+	Token    string
+	Provider string
+	Subject  string
+	Email    string
+	Username string // suggested from the provider's claims
+	Next     string
+}
+
+// Delete the token when it's been used up.
+func (t OIDCSignupToken) Delete() error {
+	return redis.Delete(fmt.Sprintf(config.OIDCSignupRedisKey, t.Token))
+}
+
+// OIDCLogin sends the user to the identity provider to log in (/auth/oidc/login).
+//
+// A POST with intent=link (from the settings page) links the provider to the logged-in account.
+func OIDCLogin() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			name = r.FormValue("provider")
+			next = r.FormValue("next")
+			link = r.Method == http.MethodPost && r.PostFormValue("intent") == "link"
+		)
+
+		provider, err := oidc.Get(name)
+		if err != nil {
+			session.FlashError(w, r, "%s", err)
+			templates.Redirect(w, "/login")
+			return
+		}
+
+		// Bind the state to this browser's session, saving it so the cookie is set.
+		sess := session.Get(r)
+		sess.Save(w)
+
+		state := OIDCState{
+			SessionUUID: sess.UUID,
+			Provider:    provider.Name,
+			Next:        next,
+		}
+
+		if link {
+			if session.Impersonated(r) {
+				session.FlashError(w, r, "You can not make changes to this account while impersonating it.")
+				templates.Redirect(w, "/settings#logins")
+				return
+			}
+
+			currentUser, err := session.CurrentUser(r)
+			if err != nil {
+				session.FlashError(w, r, "You must be logged in to link another login.")
+				templates.Redirect(w, "/login")
+				return
+			}
+			state.LinkUserID = currentUser.ID
+		}
+
+		for _, v := range []*string{&state.State, &state.Nonce, &state.Verifier} {
+			if *v, err = oidc.RandomString(); err != nil {
+				session.FlashError(w, r, "Couldn't begin login: %s", err)
+				templates.Redirect(w, "/login")
+				return
+			}
+		}
+
+		authURL, err := provider.AuthCodeURL(state.State, state.Nonce, state.Verifier)
+		if err != nil {
+			log.Error("OIDCLogin(%s): %s", provider.Name, err)
+			session.FlashError(w, r, "Couldn't reach %s to log in. Please try again later.", provider.Label)
+			templates.Redirect(w, "/login")
+			return
+		}
+
+		if err := redis.Set(fmt.Sprintf(config.OIDCStateRedisKey, state.State), state, config.OIDCStateExpires); err != nil {
+			session.FlashError(w, r, "Couldn't begin login: %s", err)
+			templates.Redirect(w, "/login")
+			return
+		}
+
+		templates.Redirect(w, authURL)
+	})
+}
+
+// OIDCCallback handles the user's return from the identity provider (/auth/oidc/callback).
+func OIDCCallback() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			stateStr = r.FormValue("state")
+			code     = r.FormValue("code")
+			state    OIDCState
+		)
+
+		// Validate the state: it must exist and belong to this browser.
+		if err := redis.Get(fmt.Sprintf(config.OIDCStateRedisKey, stateStr), &state); err != nil || state.State != stateStr || stateStr == "" {
+			session.FlashError(w, r, "Your login attempt has expired or was invalid. Please try again.")
+			templates.Redirect(w, "/login")
+			return
+		}
+		if err := state.Delete(); err != nil {
+			log.Error("OIDCState.Delete(%s): %s", state.State, err)
+		}
+		if state.SessionUUID != session.Get(r).UUID {
+			session.FlashError(w, r, "Your login attempt was started from a different browser. Please try again.")
+			templates.Redirect(w, "/login")
+			return
+		}
+
+		provider, err := oidc.Get(state.Provider)
+		if err != nil {
+			session.FlashError(w, r, "%s", err)
+			templates.Redirect(w, "/login")
+			return
+		}
+
+		// Did the provider return an error (e.g. the user clicked Cancel)?
+		if errStr := r.FormValue("error"); errStr != "" {
+			session.FlashError(w, r, "Login with %s was not completed: %s", provider.Label, errStr)
+			templates.Redirect(w, "/login")
+			return
+		}
+
+		claims, err := provider.Exchange(code, state.Verifier, state.Nonce)
+		if err != nil {
+			log.Error("OIDCCallback(%s): %s", provider.Name, err)
+			session.FlashError(w, r, "Couldn't verify your login with %s. Please try again.", provider.Label)
+			templates.Redirect(w, "/login")
+			return
+		}
+
+		identity, identityErr := models.FindExternalIdentity(provider.Name, claims.Subject)
+
+		// Linking to the logged-in account?
+		if state.LinkUserID > 0 {
+			currentUser, err := session.CurrentUser(r)
+			if err != nil || currentUser.ID != state.LinkUserID {
+				session.FlashError(w, r, "You must be logged in to link another login.")
+				templates.Redirect(w, "/login")
+				return
+			}
+
+			if identityErr == nil {
+				if identity.UserID == currentUser.ID {
+					session.Flash(w, r, "Your %s login is already linked to your account.", provider.Label)
+				} else {
+					session.FlashError(w, r, "That %s login is already linked to a different account.", provider.Label)
+				}
+				templates.Redirect(w, "/settings#logins")
+				return
+			}
+
+			if _, err := models.LinkExternalIdentity(currentUser, provider.Name, claims.Subject, claims.Email); err != nil {
+				session.FlashError(w, r, "Couldn't link your %s login: %s", provider.Label, err)
+			} else {
+				session.Flash(w, r, "Your %s login has been linked. You can now use it to log in.", provider.Label)
+			}
+			templates.Redirect(w, "/settings#logins")
+			return
+		}
+
+		// Logging in to a linked account?
+		if identityErr == nil {
+			user, err := models.GetUser(identity.UserID)
+			if err != nil {
+				session.FlashError(w, r, "Couldn't find the account linked to your %s login.", provider.Label)
+				templates.Redirect(w, "/login")
+				return
+			}
+
+			finishLogin(w, r, user, state.Next)
+			return
+		}
+
+		// A new user. We need a verified email address to create an account.
+		if claims.Email == "" || !claims.EmailVerified {
+			session.FlashError(w, r, "%s did not share a verified email address, so we can't create an account for you.", provider.Label)
+			templates.Redirect(w, "/signup")
+			return
+		}
+
+		// Don't take over an existing account by email: they must log in and link it themselves.
+		if _, err := models.FindUser(strings.ToLower(claims.Email)); err == nil {
+			session.FlashError(w, r,
+				"There is already an account with the email address %s. Please log in with your password, "+
+					"then link your %s login from your Settings page.",
+				claims.Email, provider.Label,
+			)
+			templates.Redirect(w, "/login")
+			return
+		}
+
+		// Have them pick a username.
+		token := OIDCSignupToken{
+			Provider: provider.Name,
+			Subject:  claims.Subject,
+			Email:    strings.ToLower(claims.Email),
+			Username: suggestUsername(claims),
+			Next:     state.Next,
+		}
+		if token.Token, err = oidc.RandomString(); err != nil {
+			session.FlashError(w, r, "Couldn't continue signing up: %s", err)
+			templates.Redirect(w, "/signup")
+			return
+		}
+		if err := redis.Set(fmt.Sprintf(config.OIDCSignupRedisKey, token.Token), token, config.OIDCStateExpires); err != nil {
+			session.FlashError(w, r, "Couldn't continue signing up: %s", err)
+			templates.Redirect(w, "/signup")
+			return
+		}
+
+		templates.Redirect(w, router.MustURLFor("oidc.signup", "token", token.Token))
+	})
+}
+
+// OIDCSignup lets a new user who logged in with a provider choose a username (/auth/oidc/signup).
+func OIDCSignup() http.HandlerFunc {
+	tmpl := templates.Must("account/oidc_signup.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			tokenStr = r.FormValue("token")
+			token    OIDCSignupToken
+		)
+
+		if err := redis.Get(fmt.Sprintf(config.OIDCSignupRedisKey, tokenStr), &token); err != nil || token.Token != tokenStr || tokenStr == "" {
+			session.FlashError(w, r, "Your sign up link has expired. Please try again.")
+			templates.Redirect(w, "/signup")
+			return
+		}
+
+		provider, err := oidc.Get(token.Provider)
+		if err != nil {
+			session.FlashError(w, r, "%s", err)
+			templates.Redirect(w, "/signup")
+			return
+		}
+
+		var vars = map[string]interface{}{
+			"Token":           token,
+			"Provider":        provider,
+			"Username":        token.Username,
+			"InvitesRequired": config.Current.Invites.Required,
+			"InviteCode":      models.NormalizeInviteCode(r.FormValue("invite")),
+		}
+
+		// Posting?
+		if r.Method == http.MethodPost {
+			var (
+				username = strings.TrimSpace(strings.ToLower(r.PostFormValue("username")))
+				confirm  = r.PostFormValue("confirm") == "true"
+			)
+			vars["Username"] = username
+
+			if err := validateUsername(username); err != nil {
+				session.FlashError(w, r, err.Error())
+			} else if !confirm {
+				session.FlashError(w, r, "Confirm that you have read the rules.")
+			} else {
+				// They log in with the provider, so give them a random password. They can set one
+				// later with the forgot password flow.
+				password, err := oidc.RandomString()
+				if err != nil {
+					session.FlashError(w, r, "Couldn't create your account: %s", err)
+					templates.Redirect(w, r.URL.Path+"?token="+url.QueryEscape(token.Token))
+					return
+				}
+
+				user, err := createUser(username, token.Email, password, vars["InviteCode"].(string))
+				if err != nil {
+					session.FlashError(w, r, err.Error())
+				} else {
+					if _, err := models.LinkExternalIdentity(user, provider.Name, token.Subject, token.Email); err != nil {
+						log.Error("OIDCSignup: couldn't link %s identity for new user %s: %s", provider.Name, user.Username, err)
+					}
+
+					// Burn the signup token.
+					if err := token.Delete(); err != nil {
+						log.Error("OIDCSignupToken.Delete(%s): %s", token.Token, err)
+					}
+
+					session.Flash(w, r, "User account created. Now logged in as %s.", user.Username)
+					session.LoginUser(w, r, user)
+					if strings.HasPrefix(token.Next, "/") {
+						templates.Redirect(w, token.Next)
+					} else {
+						templates.Redirect(w, "/me")
+					}
+					return
+				}
+			}
+		}
+
+		if err := tmpl.Execute(w, r, vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// suggestUsername picks a starting username for a new account from the provider's claims.
+func suggestUsername(claims *oidc.Claims) string {
+	var suggestion = claims.PreferredUsername
+	if suggestion == "" {
+		suggestion = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+
+	suggestion = usernameInvalidChars.ReplaceAllString(strings.ToLower(suggestion), "")
+	if len(suggestion) > 32 {
+		suggestion = suggestion[:32]
+	}
+
+	// Don't suggest one that's taken or not allowed.
+	if validateUsername(suggestion) != nil {
+		return ""
+	}
+	if _, err := models.FindUser(suggestion); err == nil {
+		return ""
+	}
+	return suggestion
+}
@@ -0,0 +1,40 @@
+package account
+
+import (
+	"net/http"
+
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/router"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+// Profile page of a member (/u/{username}).
+func Profile() http.HandlerFunc {
+	tmpl := templates.Must("account/profile.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var username = router.Param(r, "username")
+
+		// The viewer may be logged out.
+		currentUser, err := session.CurrentUser(r)
+		if err != nil {
+			currentUser = nil
+		}
+
+		user, err := models.FindProfile(username, currentUser)
+		if err != nil {
+			templates.NotFoundPage(w, r)
+			return
+		}
+
+		var vars = map[string]interface{}{
+			"User":          user,
+			"IsOwnProfile":  currentUser != nil && currentUser.ID == user.ID,
+			"IsAdminViewer": currentUser.HasPermission(models.PermissionUsersView),
+		}
+		if err := tmpl.Execute(w, r, vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
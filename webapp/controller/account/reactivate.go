@@ -0,0 +1,75 @@
+package account
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+// Reactivate page: offered to users who log in to an account they had deactivated.
+func Reactivate() http.HandlerFunc {
+	tmpl := templates.Must("account/reactivate.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var next = r.FormValue("next")
+		if !strings.HasPrefix(next, "/") {
+			next = "/me"
+		}
+
+		currentUser, err := session.CurrentUser(r)
+		if err != nil {
+			templates.Redirect(w, "/login")
+			return
+		}
+
+		// Nothing to do if their account isn't deactivated.
+		if currentUser.Status != models.UserStatusDeactivated {
+			templates.Redirect(w, next)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			switch r.PostFormValue("intent") {
+			case "reactivate":
+				if session.Impersonated(r) {
+					session.FlashError(w, r, "You can not make changes to this account while impersonating it.")
+					templates.Redirect(w, r.URL.Path)
+					return
+				}
+
+				currentUser.Status = models.UserStatusActive
+				if err := currentUser.Save(); err != nil {
+					session.FlashError(w, r, "Couldn't reactivate your account: %s", err)
+					templates.Redirect(w, r.URL.Path)
+					return
+				}
+				session.Audit(r, currentUser, models.AuditLog{
+					Action: models.AuditReactivate,
+					Before: models.UserStatusDeactivated,
+					After:  string(currentUser.Status),
+				})
+
+				session.Flash(w, r, "Welcome back! Your account has been reactivated.")
+				templates.Redirect(w, next)
+			case "logout":
+				session.LogoutUser(w, r)
+				session.Flash(w, r, "Your account is still deactivated and you are now logged out.")
+				templates.Redirect(w, "/")
+			default:
+				session.FlashError(w, r, "Unknown POST intent value. Please try again.")
+				templates.Redirect(w, r.URL.Path)
+			}
+			return
+		}
+
+		var vars = map[string]interface{}{
+			"Next": next,
+		}
+		if err := tmpl.Execute(w, r, vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
@@ -10,7 +10,9 @@ import (
 	"github.com/aichaos/silhouette/webapp/log"
 	"github.com/aichaos/silhouette/webapp/mail"
 	"github.com/aichaos/silhouette/webapp/models"
+	passwd "github.com/aichaos/silhouette/webapp/password"
 	"github.com/aichaos/silhouette/webapp/redis"
+	"github.com/aichaos/silhouette/webapp/router"
 	"github.com/aichaos/silhouette/webapp/session"
 	"github.com/aichaos/silhouette/webapp/templates"
 )
@@ -90,8 +92,10 @@ func ForgotPassword() http.HandlerFunc {
 
 			// With a validated token?
 			if token.Token != "" {
-				if password1 == "" {
-					session.FlashError(w, r, "A password is required.")
+				if err := passwd.Check("password", password1, user.Username, user.Email); err != nil {
+					for _, message := range passwd.Messages(err) {
+						session.FlashError(w, r, message)
+					}
 					templates.Redirect(w, r.URL.Path+"?token="+token.Token)
 					return
 				} else if password1 != password2 {
@@ -107,11 +111,18 @@ func ForgotPassword() http.HandlerFunc {
 					templates.Redirect(w, r.URL.Path+"?token="+token.Token)
 					return
 				} else {
+					session.Audit(r, user, models.AuditLog{Action: models.AuditPasswordReset})
+
 					// All done! Burn the reset token.
 					if err := token.Delete(); err != nil {
 						log.Error("ResetToken.Delete(%s): %s", token.Token, err)
 					}
 
+					// Sign out everywhere else the old password was used.
+					if err := session.RevokeAllSessions(user.ID, ""); err != nil {
+						log.Error("ForgotPassword: couldn't revoke sessions for %s: %s", user.Username, err)
+					}
+
 					if err := session.LoginUser(w, r, user); err != nil {
 						session.FlashError(w, r, "Your password was reset and you can now log in.")
 						templates.Redirect(w, "/login")
@@ -129,7 +140,7 @@ func ForgotPassword() http.HandlerFunc {
 				UserID: user.ID,
 				Token:  uuid.New().String(),
 			}
-			if err := redis.Set(fmt.Sprintf(config.ResetPasswordRedisKey, token.Token), token, config.SignupTokenExpires); err != nil {
+			if err := redis.SetUserToken(user.ID, fmt.Sprintf(config.ResetPasswordRedisKey, token.Token), token, config.SignupTokenExpires); err != nil {
 				session.FlashError(w, r, "Couldn't create a reset token: %s", err)
 				templates.Redirect(w, r.URL.Path)
 				return
@@ -142,7 +153,7 @@ func ForgotPassword() http.HandlerFunc {
 				Template: "email/reset_password.html",
 				Data: map[string]interface{}{
 					"Username": user.Username,
-					"URL":      config.Current.BaseURL + "/forgot-password?token=" + token.Token,
+					"URL":      router.MustAbsoluteURLFor("forgot_password", "token", token.Token),
 				},
 			}); err != nil {
 				session.FlashError(w, r, "Error sending an email: %s", err)
@@ -53,9 +53,15 @@ func Search() http.HandlerFunc {
 		}
 		pager.ParsePage(r)
 
-		users, err := models.SearchUsers(currentUser, &models.UserSearch{
+		// Members only find the accounts whose profiles they may see.
+		var search = &models.UserSearch{
 			EmailOrUsername: username,
-		}, pager)
+		}
+		if !currentUser.HasPermission(models.PermissionUsersView) {
+			search.Status = models.UserStatusActive
+		}
+
+		users, err := models.SearchUsers(currentUser, search, pager)
 		if err != nil {
 			session.FlashError(w, r, "Couldn't search users: %s", err)
 		}
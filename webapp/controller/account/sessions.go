@@ -0,0 +1,64 @@
+package account
+
+import (
+	"net/http"
+
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+// Sessions page lists where the user is logged in and lets them log out remotely (/settings/sessions).
+func Sessions() http.HandlerFunc {
+	tmpl := templates.Must("account/sessions.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currentUser, err := session.CurrentUser(r)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't get CurrentUser: %s", err)
+			templates.Redirect(w, "/")
+			return
+		}
+
+		var sess = session.Get(r)
+
+		// Are we POSTing?
+		if r.Method == http.MethodPost {
+			intent := r.PostFormValue("intent")
+			switch intent {
+			case "revoke":
+				var uuid = r.PostFormValue("uuid")
+				if uuid == sess.UUID {
+					session.FlashError(w, r, "To sign out of this device, use the Log out link instead.")
+				} else if err := session.RevokeSession(currentUser.ID, uuid); err != nil {
+					session.FlashError(w, r, "Couldn't sign out that session: %s", err)
+				} else {
+					session.Flash(w, r, "That session has been signed out.")
+				}
+			case "revoke-others":
+				if err := session.RevokeAllSessions(currentUser.ID, sess.UUID); err != nil {
+					session.FlashError(w, r, "Couldn't sign out your other sessions: %s", err)
+				} else {
+					session.Flash(w, r, "All of your other sessions have been signed out.")
+				}
+			default:
+				session.FlashError(w, r, "Unknown POST intent value. Please try again.")
+			}
+
+			templates.Redirect(w, r.URL.Path)
+			return
+		}
+
+		sessions, err := session.ListSessions(currentUser.ID)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't list your sessions: %s", err)
+		}
+
+		var vars = map[string]interface{}{
+			"Sessions":    sessions,
+			"CurrentUUID": sess.UUID,
+		}
+		if err := tmpl.Execute(w, r, vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
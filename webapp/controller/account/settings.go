@@ -2,18 +2,25 @@ package account
 
 import (
 	"fmt"
+	"html/template"
 	"net/http"
 	nm "net/mail"
+	"strconv"
 	"strings"
 
 	"github.com/google/uuid"
 	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/invites"
 	"github.com/aichaos/silhouette/webapp/log"
 	"github.com/aichaos/silhouette/webapp/mail"
 	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/oidc"
+	passwd "github.com/aichaos/silhouette/webapp/password"
 	"github.com/aichaos/silhouette/webapp/redis"
+	"github.com/aichaos/silhouette/webapp/router"
 	"github.com/aichaos/silhouette/webapp/session"
 	"github.com/aichaos/silhouette/webapp/templates"
+	"github.com/aichaos/silhouette/webapp/totp"
 )
 
 // ChangeEmailToken for Redis.
@@ -83,7 +90,7 @@ func Settings() http.HandlerFunc {
 						UserID:   user.ID,
 						NewEmail: changeEmail,
 					}
-					if err := redis.Set(fmt.Sprintf(config.ChangeEmailRedisKey, token.Token), token, config.SignupTokenExpires); err != nil {
+					if err := redis.SetUserToken(user.ID, fmt.Sprintf(config.ChangeEmailRedisKey, token.Token), token, config.SignupTokenExpires); err != nil {
 						session.FlashError(w, r, "Failed to create change email token: %s", err)
 						templates.Redirect(w, r.URL.Path)
 						return
@@ -95,7 +102,7 @@ func Settings() http.HandlerFunc {
 						Template: "email/verify_email.html",
 						Data: map[string]interface{}{
 							"Title":       config.Title,
-							"URL":         config.Current.BaseURL + "/settings/confirm-email?token=" + token.Token,
+							"URL":         router.MustAbsoluteURLFor("settings.confirm_email", "token", token.Token),
 							"ChangeEmail": true,
 						},
 					})
@@ -108,8 +115,11 @@ func Settings() http.HandlerFunc {
 
 				// Changing their password?
 				if password1 != "" {
-					if password2 != password1 {
-						log.Error("pw1=%s  pw2=%s", password1, password2)
+					if err := passwd.Check("new_password", password1, user.Username, user.Email); err != nil {
+						for _, message := range passwd.Messages(err) {
+							session.FlashError(w, r, "Couldn't change your password: %s", message)
+						}
+					} else if password2 != password1 {
 						session.FlashError(w, r, "Couldn't change your password: your new passwords do not match.")
 					} else {
 						// Hash the new password.
@@ -121,10 +131,156 @@ func Settings() http.HandlerFunc {
 								session.FlashError(w, r, "Failed to update your password in the database: %s", err)
 							} else {
 								session.Flash(w, r, "Your password has been updated.")
+								session.Audit(r, user, models.AuditLog{Action: models.AuditPasswordChange})
+
+								// Sign out their other sessions.
+								if err := session.RevokeAllSessions(user.ID, session.Get(r).UUID); err != nil {
+									log.Error("Settings: couldn't revoke sessions for %s: %s", user.Username, err)
+								}
 							}
 						}
 					}
 				}
+			case "oidc-unlink":
+				idInt, err := strconv.Atoi(r.PostFormValue("identity_id"))
+				if err != nil {
+					session.FlashError(w, r, "Invalid identity ID.")
+				} else if identity, err := models.GetExternalIdentity(user.ID, uint64(idInt)); err != nil {
+					session.FlashError(w, r, "That login is not linked to your account.")
+				} else if err := identity.Delete(); err != nil {
+					session.FlashError(w, r, "Couldn't unlink the login: %s", err)
+				} else {
+					session.Flash(w, r, "The login has been unlinked from your account.")
+				}
+				templates.Redirect(w, r.URL.Path+"#logins")
+				return
+			case "2fa-setup":
+				// Begin enrolling in two-factor authentication with a new secret.
+				if _, err := models.NewTwoFactor(user); err != nil {
+					session.FlashError(w, r, "Couldn't set up two-factor authentication: %s", err)
+				}
+				templates.Redirect(w, r.URL.Path+"#2fa")
+				return
+			case "2fa-enable":
+				var code = r.PostFormValue("code")
+
+				// Confirm their authenticator app gives the right code.
+				tf, err := models.GetTwoFactor(user.ID)
+				if err != nil || tf.Enabled {
+					session.FlashError(w, r, "Please begin two-factor setup again.")
+					templates.Redirect(w, r.URL.Path+"#2fa")
+					return
+				}
+
+				if !tf.Validate(code) {
+					session.FlashError(w, r, "That code was not correct. Please check your authenticator app and try again.")
+					templates.Redirect(w, r.URL.Path+"#2fa")
+					return
+				}
+
+				// Turn it on and issue recovery codes.
+				codes, err := tf.GenerateRecoveryCodes()
+				if err != nil {
+					session.FlashError(w, r, "Couldn't generate recovery codes: %s", err)
+					templates.Redirect(w, r.URL.Path+"#2fa")
+					return
+				}
+				tf.Enabled = true
+				if err := tf.Save(); err != nil {
+					session.FlashError(w, r, "Couldn't save your two-factor settings: %s", err)
+					templates.Redirect(w, r.URL.Path+"#2fa")
+					return
+				}
+				session.Audit(r, user, models.AuditLog{Action: models.AuditTwoFactorEnable})
+
+				// Show them their recovery codes (only this once).
+				session.Flash(w, r, "Two-factor authentication is now enabled on your account.")
+				vars["TwoFactor"] = tf
+				vars["RecoveryCodes"] = codes
+				if err := tmpl.Execute(w, r, vars); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			case "2fa-recovery-codes", "2fa-disable":
+				var password = r.PostFormValue("password")
+
+				// Their password is needed to change two-factor settings.
+				if err := user.CheckPassword(password); err != nil {
+					session.FlashError(w, r, "The password you entered was incorrect.")
+					templates.Redirect(w, r.URL.Path+"#2fa")
+					return
+				}
+
+				tf, err := models.GetTwoFactor(user.ID)
+				if err != nil {
+					session.FlashError(w, r, "Two-factor authentication is not enabled on your account.")
+					templates.Redirect(w, r.URL.Path+"#2fa")
+					return
+				}
+
+				// Turning it off?
+				if intent == "2fa-disable" {
+					if err := tf.Delete(); err != nil {
+						session.FlashError(w, r, "Couldn't disable two-factor authentication: %s", err)
+					} else {
+						session.Flash(w, r, "Two-factor authentication has been disabled on your account.")
+						session.Audit(r, user, models.AuditLog{Action: models.AuditTwoFactorDisable})
+					}
+					templates.Redirect(w, r.URL.Path+"#2fa")
+					return
+				}
+
+				// Issue a fresh set of recovery codes.
+				codes, err := tf.GenerateRecoveryCodes()
+				if err != nil {
+					session.FlashError(w, r, "Couldn't generate recovery codes: %s", err)
+					templates.Redirect(w, r.URL.Path+"#2fa")
+					return
+				}
+				if err := tf.Save(); err != nil {
+					session.FlashError(w, r, "Couldn't save your recovery codes: %s", err)
+					templates.Redirect(w, r.URL.Path+"#2fa")
+					return
+				}
+
+				session.Flash(w, r, "New recovery codes have been generated and your old ones will no longer work.")
+				vars["TwoFactor"] = tf
+				vars["RecoveryCodes"] = codes
+				if err := tmpl.Execute(w, r, vars); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			case "deactivate":
+				var password = r.PostFormValue("password")
+
+				// Their password is needed to deactivate their account.
+				if err := user.CheckPassword(password); err != nil {
+					session.FlashError(w, r, "You must enter your correct account password to deactivate your account.")
+					templates.Redirect(w, r.URL.Path+"#deactivate")
+					return
+				}
+
+				var oldStatus = user.Status
+				user.Status = models.UserStatusDeactivated
+				if err := user.Save(); err != nil {
+					session.FlashError(w, r, "Couldn't deactivate your account: %s", err)
+					templates.Redirect(w, r.URL.Path+"#deactivate")
+					return
+				}
+				session.Audit(r, user, models.AuditLog{
+					Action: models.AuditDeactivate,
+					Before: string(oldStatus),
+					After:  string(user.Status),
+				})
+
+				// Sign them out everywhere.
+				if err := session.RevokeAllSessions(user.ID, ""); err != nil {
+					log.Error("Settings: couldn't revoke sessions of deactivated user %s: %s", user.Username, err)
+				}
+				session.LogoutUser(w, r)
+				session.Flash(w, r, "Your account has been deactivated. Log in again at any time to reactivate it.")
+				templates.Redirect(w, "/")
+				return
 			default:
 				session.FlashError(w, r, "Unknown POST intent value. Please try again.")
 			}
@@ -133,6 +289,31 @@ func Settings() http.HandlerFunc {
 			return
 		}
 
+		vars["CanInvite"] = invites.CanInvite(user)
+
+		// Linked logins with identity providers.
+		vars["OIDCProviders"] = oidc.Providers()
+		if identities, err := models.GetExternalIdentities(user.ID); err == nil {
+			vars["ExternalIdentities"] = identities
+		} else {
+			log.Error("Settings: couldn't get external identities for %s: %s", user.Username, err)
+		}
+
+		// Two-factor auth settings.
+		if tf, err := models.GetTwoFactor(user.ID); err == nil {
+			vars["TwoFactor"] = tf
+			if !tf.Enabled {
+				// Enrollment in progress: show the QR code for their authenticator app.
+				uri := tf.URI(user)
+				vars["TwoFactorURI"] = uri
+				if qr, err := totp.QRCode(uri); err == nil {
+					vars["TwoFactorQR"] = template.URL(qr)
+				} else {
+					log.Error("Settings: couldn't render 2FA QR code: %s", err)
+				}
+			}
+		}
+
 		if err := tmpl.Execute(w, r, vars); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -174,10 +355,16 @@ func ConfirmEmailChange() http.HandlerFunc {
 			}
 
 			// Make the change.
+			var oldEmail = user.Email
 			user.Email = token.NewEmail
 			if err := user.Save(); err != nil {
 				session.FlashError(w, r, "Couldn't save the change to your user: %s", err)
 			} else {
+				session.Audit(r, user, models.AuditLog{
+					Action: models.AuditEmailChange,
+					Before: oldEmail,
+					After:  user.Email,
+				})
 				session.Flash(w, r, "Your email address has been confirmed and updated.")
 				templates.Redirect(w, "/")
 			}
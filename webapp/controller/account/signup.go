@@ -1,17 +1,21 @@
 package account
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	nm "net/mail"
 	"strings"
 
 	"github.com/google/uuid"
+	"github.com/aichaos/silhouette/webapp/approval"
 	"github.com/aichaos/silhouette/webapp/config"
 	"github.com/aichaos/silhouette/webapp/log"
 	"github.com/aichaos/silhouette/webapp/mail"
 	"github.com/aichaos/silhouette/webapp/models"
+	passwd "github.com/aichaos/silhouette/webapp/password"
 	"github.com/aichaos/silhouette/webapp/redis"
+	"github.com/aichaos/silhouette/webapp/router"
 	"github.com/aichaos/silhouette/webapp/session"
 	"github.com/aichaos/silhouette/webapp/templates"
 )
@@ -19,8 +23,9 @@ import (
 // SignupToken goes in Redis when the user first gives us their email address. They
 // verify their email before signing up, so cache only in Redis until verified.
 type SignupToken struct {
-	Email string
-	Token string
+	Email      string
+	Token      string
+	InviteCode string // when signup is invitation-only
 }
 
 // Delete a SignupToken when it's been used up.
@@ -38,6 +43,8 @@ func Signup() http.HandlerFunc {
 			"SignupToken":           "",    // non-empty if user has clicked verification link
 			"SkipEmailVerification": false, // true if email verification is disabled
 			"Email":                 "",    // pre-filled user email
+			"InvitesRequired":       config.Current.Invites.Required,
+			"InviteCode":            models.NormalizeInviteCode(r.FormValue("invite")),
 		}
 
 		// Is email verification disabled?
@@ -63,6 +70,7 @@ func Signup() http.HandlerFunc {
 
 			vars["SignupToken"] = tokenStr
 			vars["Email"] = token.Email
+			vars["InviteCode"] = token.InviteCode
 		}
 		log.Info("Vars: %+v", vars)
 
@@ -77,6 +85,9 @@ func Signup() http.HandlerFunc {
 				username  = strings.TrimSpace(strings.ToLower(r.PostFormValue("username")))
 				password  = strings.TrimSpace(r.PostFormValue("password"))
 				password2 = strings.TrimSpace(r.PostFormValue("password2"))
+
+				// Invite code, from the form or carried in the verification token.
+				inviteCode = vars["InviteCode"].(string)
 			)
 
 			// Don't let them sneakily change their verified email address on us.
@@ -87,12 +98,10 @@ func Signup() http.HandlerFunc {
 			}
 
 			// Reserved username check.
-			for _, cmp := range config.ReservedUsernames {
-				if username == cmp {
-					session.FlashError(w, r, "That username is reserved, please choose a different username.")
-					templates.Redirect(w, r.URL.Path+"?token="+tokenStr)
-					return
-				}
+			if isReservedUsername(username) {
+				session.FlashError(w, r, "That username is reserved, please choose a different username.")
+				templates.Redirect(w, r.URL.Path+"?token="+tokenStr)
+				return
 			}
 
 			// Cache username in case of passwd validation errors.
@@ -128,12 +137,22 @@ func Signup() http.HandlerFunc {
 				return
 			}
 
+			// Invitation only?
+			if config.Current.Invites.Required {
+				if _, err := models.FindInvite(inviteCode); err != nil {
+					session.FlashError(w, r, "Signing up is by invitation only: %s.", err)
+					templates.Redirect(w, r.URL.Path)
+					return
+				}
+			}
+
 			// Email verification step!
 			if !config.SkipEmailVerification && vars["SignupToken"] == "" {
 				// Create a SignupToken verification link to send to their inbox.
 				token = SignupToken{
-					Email: email,
-					Token: uuid.New().String(),
+					Email:      email,
+					Token:      uuid.New().String(),
+					InviteCode: inviteCode,
 				}
 				if err := redis.Set(fmt.Sprintf(config.SignupTokenRedisKey, token.Token), token, config.SignupTokenExpires); err != nil {
 					session.FlashError(w, r, "Error creating a link to send you: %s", err)
@@ -145,7 +164,7 @@ func Signup() http.HandlerFunc {
 					Template: "email/verify_email.html",
 					Data: map[string]interface{}{
 						"Title": config.Title,
-						"URL":   config.Current.BaseURL + "/signup?token=" + token.Token,
+						"URL":   router.MustAbsoluteURLFor("signup", "token", token.Token),
 					},
 				})
 				if err != nil {
@@ -159,8 +178,10 @@ func Signup() http.HandlerFunc {
 
 			// Full sign-up step (w/ email verification token), validate more things.
 			var hasError bool
-			if len(password) < 3 {
-				session.FlashError(w, r, "Please enter a password longer than 3 characters.")
+			if err := passwd.Check("password", password, username, email); err != nil {
+				for _, message := range passwd.Messages(err) {
+					session.FlashError(w, r, message)
+				}
 				hasError = true
 			} else if password != password2 {
 				session.FlashError(w, r, "Your passwords do not match.")
@@ -174,7 +195,7 @@ func Signup() http.HandlerFunc {
 
 			// Looking good?
 			if !hasError {
-				user, err := models.CreateUser(username, email, password)
+				user, err := createUser(username, email, password, inviteCode)
 				if err != nil {
 					session.FlashError(w, r, err.Error())
 				} else {
@@ -200,3 +221,64 @@ func Signup() http.HandlerFunc {
 		}
 	})
 }
+
+// createUser creates an account at signup. The invite code, when one is given or signup is
+// invitation-only, is used up and recorded on the account, and the account is held for approval
+// if the settings require it.
+func createUser(username, email, password, inviteCode string) (*models.User, error) {
+	var invite *models.Invite
+	if inviteCode != "" || config.Current.Invites.Required {
+		found, err := models.FindInvite(inviteCode)
+		if err == nil {
+			err = found.Claim()
+		}
+
+		if err == nil {
+			invite = found
+		} else if config.Current.Invites.Required {
+			return nil, fmt.Errorf("Signing up is by invitation only: %s.", err)
+		}
+	}
+
+	// Held for approval from the start, if required, so no failure can leave it active.
+	user, err := models.CreateUserWithStatus(username, email, password, approval.NewStatus())
+	if err != nil {
+		if invite != nil {
+			if err := invite.Release(); err != nil {
+				log.Error("createUser: couldn't release invite %d: %s", invite.ID, err)
+			}
+		}
+		return nil, err
+	}
+
+	if invite != nil {
+		user.InviteID = invite.ID
+		if err := user.Save(); err != nil {
+			log.Error("createUser: couldn't record invite %d on user %s: %s", invite.ID, user.Username, err)
+		}
+	}
+
+	approval.Hold(user)
+	return user, nil
+}
+
+// isReservedUsername checks the username against config.ReservedUsernames.
+func isReservedUsername(username string) bool {
+	for _, cmp := range config.ReservedUsernames {
+		if username == cmp {
+			return true
+		}
+	}
+	return false
+}
+
+// validateUsername applies the signup rules for new usernames (format and reserved names).
+func validateUsername(username string) error {
+	if isReservedUsername(username) {
+		return errors.New("That username is reserved, please choose a different username.")
+	}
+	if !config.UsernameRegexp.MatchString(username) {
+		return errors.New("Your username must consist of only numbers, letters, - . and be 3-32 characters.")
+	}
+	return nil
+}
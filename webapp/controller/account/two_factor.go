@@ -0,0 +1,135 @@
+package account
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/ratelimit"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/suspension"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+// LoginTwoFactor is the second step of login for users with two-factor auth enabled (/login/2fa).
+func LoginTwoFactor() http.HandlerFunc {
+	tmpl := templates.Must("account/login_2fa.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			next  = r.FormValue("next")
+			retry = r.URL.Path + "?next=" + url.QueryEscape(next)
+		)
+
+		// They must have come here from the password step.
+		user, err := session.PendingTwoFactor(r)
+		if err != nil {
+			session.FlashError(w, r, "Please log in with your username and password first.")
+			templates.Redirect(w, "/login")
+			return
+		}
+
+		// Posting?
+		if r.Method == http.MethodPost {
+			var (
+				code     = strings.TrimSpace(r.PostFormValue("code"))
+				recovery = r.PostFormValue("recovery") == "true"
+			)
+
+			// Cancel and start over?
+			if r.PostFormValue("intent") == "cancel" {
+				session.CancelTwoFactor(w, r)
+				templates.Redirect(w, "/login")
+				return
+			}
+
+			tf, err := models.GetTwoFactor(user.ID)
+			if err != nil || !tf.Enabled {
+				// Two-factor got turned off in the meantime?
+				session.CancelTwoFactor(w, r)
+				session.FlashError(w, r, "Please log in again.")
+				templates.Redirect(w, "/login")
+				return
+			}
+
+			// Rate limit failed code attempts. While a cooldown or lockout is running, every
+			// attempt is refused even if the code is right, so it can't be guessed through.
+			limiter := &ratelimit.Limiter{
+				Namespace:  "2fa",
+				ID:         user.ID,
+				Limit:      config.TwoFactorRateLimit,
+				Window:     config.TwoFactorRateLimitWindow,
+				CooldownAt: config.TwoFactorRateLimitCooldownAt,
+				Cooldown:   config.TwoFactorRateLimitCooldown,
+			}
+			if err := limiter.Check(); err != nil {
+				session.FlashError(w, r, err.Error())
+				templates.Redirect(w, retry)
+				return
+			}
+
+			// Verify the code.
+			var ok bool
+			if recovery {
+				ok = tf.UseRecoveryCode(code)
+			} else {
+				ok = tf.Validate(code)
+			}
+
+			if !ok {
+				if err := limiter.Ping(); err != nil {
+					session.FlashError(w, r, err.Error())
+					templates.Redirect(w, retry)
+					return
+				}
+
+				session.FlashError(w, r, "That code was not correct. Please try again.")
+				templates.Redirect(w, retry)
+				return
+			}
+
+			// Is their account suspended, banned or disabled? (Could have changed since the password step.)
+			if s, err := suspension.Check(user); err != nil {
+				log.Error("LoginTwoFactor: couldn't check suspension of %s: %s", user.Username, err)
+			} else if s != nil {
+				session.CancelTwoFactor(w, r)
+				session.FlashError(w, r, suspension.Message(s))
+				templates.Redirect(w, "/login")
+				return
+			}
+			if !user.CanLogIn() {
+				session.CancelTwoFactor(w, r)
+				session.FlashError(w, r, loginRefusedMessage(user))
+				templates.Redirect(w, "/login")
+				return
+			}
+
+			// OK. Log in the user's session.
+			session.LoginUser(w, r, user)
+
+			// Clear their rate limiter.
+			if err := limiter.Clear(); err != nil {
+				log.Error("Failed to clear 2FA rate limiter: %s", err)
+			}
+
+			// Running low on recovery codes?
+			if recovery {
+				session.Flash(w, r, "You have %d recovery code(s) remaining. You can generate new ones on your Settings page.", tf.RecoveryCodesRemaining())
+			}
+
+			redirectAfterLogin(w, r, user, next)
+			return
+		}
+
+		var vars = map[string]interface{}{
+			"Next":     next,
+			"Recovery": r.FormValue("recovery") == "true",
+		}
+		if err := tmpl.Execute(w, r, vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
@@ -0,0 +1,99 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aichaos/silhouette/webapp/approval"
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+// Approvals queue (/admin/approvals): approve or reject new accounts awaiting approval.
+func Approvals() http.HandlerFunc {
+	tmpl := templates.Must("admin/approvals.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Approving or rejecting?
+		if r.Method == http.MethodPost {
+			var (
+				intent = r.PostFormValue("intent")
+				reason = strings.TrimSpace(r.PostFormValue("reason"))
+			)
+
+			userID, err := strconv.ParseUint(r.PostFormValue("user_id"), 10, 64)
+			if err != nil {
+				session.FlashError(w, r, "Invalid or missing user_id parameter.")
+				templates.Redirect(w, r.URL.Path)
+				return
+			}
+
+			user, err := models.GetUser(userID)
+			if err != nil {
+				session.FlashError(w, r, "Didn't find user ID in database: %s", err)
+				templates.Redirect(w, r.URL.Path)
+				return
+			} else if user.Status != models.UserStatusPending {
+				session.FlashError(w, r, "The account %s is not awaiting approval.", user.Username)
+				templates.Redirect(w, r.URL.Path)
+				return
+			}
+
+			switch intent {
+			case "approve":
+				if err := approval.Approve(user); err != nil {
+					session.FlashError(w, r, "Couldn't approve %s: %s", user.Username, err)
+				} else {
+					session.Audit(r, user, models.AuditLog{
+						Action: models.AuditApprove,
+						Before: models.UserStatusPending,
+						After:  string(user.Status),
+						Reason: reason,
+					})
+					session.Flash(w, r, "The account %s has been approved.", user.Username)
+				}
+			case "reject":
+				if reason == "" {
+					session.FlashError(w, r, "Give a reason for rejecting %s; it is e-mailed to them.", user.Username)
+				} else if err := approval.Reject(user, reason); err != nil {
+					session.FlashError(w, r, "Couldn't reject %s: %s", user.Username, err)
+				} else {
+					session.Audit(r, user, models.AuditLog{
+						Action: models.AuditReject,
+						Reason: reason,
+					})
+					session.Flash(w, r, "The account %s has been rejected and removed.", user.Username)
+				}
+			default:
+				session.FlashError(w, r, "Unknown POST intent value. Please try again.")
+			}
+			templates.Redirect(w, r.URL.Path)
+			return
+		}
+
+		pager := &models.Pagination{
+			PerPage: config.PageSizeAdminApprovals,
+			Sort:    "created_at",
+		}
+		pager.ParsePage(r)
+
+		users, err := models.SearchUsers(nil, &models.UserSearch{
+			Status: models.UserStatusPending,
+		}, pager)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't get the accounts awaiting approval: %s", err)
+		}
+
+		var vars = map[string]interface{}{
+			"Users":           users,
+			"Pager":           pager,
+			"RequireApproval": config.Current.RequireApproval,
+		}
+		if err := tmpl.Execute(w, r, vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package admin
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+// AuditLog viewer (/admin/audit). Add ?format=csv to download the filtered log.
+func AuditLog() http.HandlerFunc {
+	tmpl := templates.Must("admin/audit_log.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Search filters.
+		var search = &models.AuditSearch{
+			Actor:     r.FormValue("actor"),
+			Target:    r.FormValue("target"),
+			IPAddress: r.FormValue("ip"),
+		}
+
+		// Only filter on actions we know.
+		for _, action := range models.AuditActions {
+			if r.FormValue("action") == string(action) {
+				search.Action = action
+				break
+			}
+		}
+
+		var since, until string
+		search.Since, since = parseDateFilter(w, r, "since", false)
+		search.Until, until = parseDateFilter(w, r, "until", true)
+
+		// Download the lot?
+		if r.FormValue("format") == "csv" {
+			entries, err := models.SearchAuditLogs(search, nil)
+			if err != nil {
+				session.FlashError(w, r, "Couldn't export the audit log: %s", err)
+				templates.Redirect(w, r.URL.Path)
+				return
+			}
+
+			w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+			w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="audit-log-%s.csv"`, time.Now().Format("2006-01-02")))
+			if err := writeAuditCSV(w, entries); err != nil {
+				log.Error("AuditLog: couldn't write CSV export: %s", err)
+			}
+			return
+		}
+
+		pager := &models.Pagination{
+			PerPage: config.PageSizeAuditLog,
+			Sort:    "created_at desc",
+		}
+		pager.ParsePage(r)
+
+		entries, err := models.SearchAuditLogs(search, pager)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't search the audit log: %s", err)
+		}
+
+		var vars = map[string]interface{}{
+			"Entries": entries,
+			"Pager":   pager,
+			"Actions": models.AuditActions,
+
+			// Search filter values.
+			"Actor":     search.Actor,
+			"Target":    search.Target,
+			"Action":    string(search.Action),
+			"IPAddress": search.IPAddress,
+			"Since":     since,
+			"Until":     until,
+		}
+		if err := tmpl.Execute(w, r, vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// writeAuditCSV writes audit log entries as CSV.
+func writeAuditCSV(w http.ResponseWriter, entries []*models.AuditLog) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"id", "time", "action", "actor_id", "actor", "target_id", "target",
+		"before", "after", "ip_address", "reason",
+	})
+
+	for _, entry := range entries {
+		cw.Write([]string{
+			strconv.FormatUint(entry.ID, 10),
+			entry.CreatedAt.UTC().Format(time.RFC3339),
+			string(entry.Action),
+			strconv.FormatUint(entry.ActorID, 10),
+			csvSafe(entry.ActorUsername),
+			strconv.FormatUint(entry.TargetID, 10),
+			csvSafe(entry.TargetUsername),
+			csvSafe(entry.Before),
+			csvSafe(entry.After),
+			csvSafe(entry.IPAddress),
+			csvSafe(entry.Reason),
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvSafe keeps a user-supplied value from being run as a formula when the export is opened
+// in a spreadsheet.
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -3,6 +3,8 @@ package admin
 import (
 	"net/http"
 
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/models"
 	"github.com/aichaos/silhouette/webapp/templates"
 )
 
@@ -10,7 +12,23 @@ import (
 func Dashboard() http.HandlerFunc {
 	tmpl := templates.Must("admin/dashboard.html")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if err := tmpl.Execute(w, r, nil); err != nil {
+		var vars = map[string]interface{}{}
+
+		// Accounts awaiting approval.
+		if count, err := models.CountPendingUsers(); err == nil {
+			vars["PendingApprovals"] = count
+		} else {
+			log.Error("Dashboard: couldn't count pending users: %s", err)
+		}
+
+		// Accounts pending deletion.
+		if count, err := models.CountDeletionRequests(); err == nil {
+			vars["PendingDeletions"] = count
+		} else {
+			log.Error("Dashboard: couldn't count deletion requests: %s", err)
+		}
+
+		if err := tmpl.Execute(w, r, vars); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
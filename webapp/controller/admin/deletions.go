@@ -0,0 +1,112 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/purge"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+// Deletions (/admin/deletions): accounts their owners asked to delete, which wait out the grace
+// period. Admins may delete them now or cancel the deletion.
+func Deletions() http.HandlerFunc {
+	tmpl := templates.Must("admin/deletions.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currentUser, err := session.CurrentUser(r)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't get your current user: %s", err)
+			templates.Redirect(w, "/")
+			return
+		}
+
+		// Expediting or cancelling?
+		if r.Method == http.MethodPost {
+			var intent = r.PostFormValue("intent")
+
+			userID, err := strconv.ParseUint(r.PostFormValue("user_id"), 10, 64)
+			if err != nil {
+				session.FlashError(w, r, "Invalid or missing user_id parameter.")
+				templates.Redirect(w, r.URL.Path)
+				return
+			}
+
+			user, err := models.GetUser(userID)
+			if err != nil {
+				session.FlashError(w, r, "Didn't find user ID in database: %s", err)
+				templates.Redirect(w, r.URL.Path)
+				return
+			} else if user.Status != models.UserStatusPendingDeletion {
+				session.FlashError(w, r, "The account %s is not pending deletion.", user.Username)
+				templates.Redirect(w, r.URL.Path)
+				return
+			} else if !currentUser.CanManage(user, models.PermissionUsersDelete) {
+				session.FlashError(w, r, "You may not manage the account of %s.", user.Username)
+				templates.Redirect(w, r.URL.Path)
+				return
+			}
+
+			switch intent {
+			case "expedite":
+				if err := purge.Purge(user); err != nil {
+					session.FlashError(w, r, "Couldn't delete %s: %s", user.Username, err)
+				} else {
+					session.Audit(r, user, models.AuditLog{
+						Action: models.AuditAdminDelete,
+						Before: models.UserStatusPendingDeletion,
+						Reason: "Expedited the user's deletion request.",
+					})
+					session.Flash(w, r, "The account %s has been deleted.", user.Username)
+				}
+			case "cancel":
+				if err := purge.Cancel(user); err != nil {
+					session.FlashError(w, r, "Couldn't cancel the deletion of %s: %s", user.Username, err)
+				} else {
+					session.Audit(r, user, models.AuditLog{
+						Action: models.AuditCancelDeletion,
+						Before: models.UserStatusPendingDeletion,
+						After:  string(user.Status),
+					})
+					session.Flash(w, r, "The deletion of %s has been cancelled and the account is active again.", user.Username)
+				}
+			default:
+				session.FlashError(w, r, "Unknown POST intent value. Please try again.")
+			}
+			templates.Redirect(w, r.URL.Path)
+			return
+		}
+
+		pager := &models.Pagination{
+			PerPage: config.PageSizeAdminDeletions,
+		}
+		pager.ParsePage(r)
+
+		requests, err := models.GetDeletionRequests(pager)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't get the accounts pending deletion: %s", err)
+		}
+
+		var userIDs = []uint64{}
+		for _, d := range requests {
+			userIDs = append(userIDs, d.UserID)
+		}
+		users, err := models.MapUsers(currentUser, userIDs)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't look up the users: %s", err)
+		}
+
+		var vars = map[string]interface{}{
+			"Requests":  requests,
+			"Users":     users,
+			"Pager":     pager,
+			"GraceDays": config.Current.Deletion.GraceDays,
+		}
+		if err := tmpl.Execute(w, r, vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
@@ -0,0 +1,25 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+// StopImpersonating returns an impersonating admin to their own account (/admin/unimpersonate).
+//
+// Not behind AdminRequired: while impersonating, the current user is the one being impersonated.
+func StopImpersonating() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		admin, err := session.StopImpersonating(w, r)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't return to your admin account: %s", err)
+			templates.Redirect(w, "/")
+			return
+		}
+
+		session.Flash(w, r, "You are no longer impersonating a user and are back on your own account, %s.", admin.Username)
+		templates.Redirect(w, "/admin")
+	})
+}
@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/invites"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+// Invites console (/admin/invites): issue and revoke invite codes for invitation-only signup.
+func Invites() http.HandlerFunc {
+	tmpl := templates.Must("admin/invites.html")
+
+	// Whitelists for the new invite options (0 = unlimited uses, or never expires).
+	var (
+		usesWhitelist    = []int{1, 5, 10, 25, 0}
+		expiresWhitelist = []int{7, 30, 90, 0} // days
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		currentUser, err := session.CurrentUser(r)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't get CurrentUser: %s", err)
+			templates.Redirect(w, "/admin")
+			return
+		}
+
+		// Are we POSTing?
+		if r.Method == http.MethodPost {
+			intent := r.PostFormValue("intent")
+			switch intent {
+			case "create":
+				var (
+					email     = strings.TrimSpace(r.PostFormValue("email"))
+					uses, _   = strconv.Atoi(r.PostFormValue("uses"))
+					days, _   = strconv.Atoi(r.PostFormValue("expires"))
+					expiresAt time.Time
+				)
+
+				if !inWhitelist(uses, usesWhitelist) || !inWhitelist(days, expiresWhitelist) {
+					session.FlashError(w, r, "Invalid invite options.")
+					break
+				}
+				if days > 0 {
+					expiresAt = time.Now().Add(time.Duration(days) * 24 * time.Hour)
+				}
+
+				invite, err := invites.Create(currentUser, uses, expiresAt, email)
+				if err != nil && invite == nil {
+					session.FlashError(w, r, "Couldn't create the invite: %s", err)
+				} else if err != nil {
+					session.FlashError(w, r, "Invite code %s was created but couldn't be emailed: %s", invite.Code, err)
+				} else if invite.Email != "" {
+					session.Flash(w, r, "Invite code %s created and sent to %s.", invite.Code, invite.Email)
+				} else {
+					session.Flash(w, r, "Invite code %s created.", invite.Code)
+				}
+			case "revoke":
+				inviteID, err := strconv.Atoi(r.PostFormValue("invite_id"))
+				if err != nil {
+					session.FlashError(w, r, "Invalid invite ID.")
+				} else if invite, err := models.GetInvite(uint64(inviteID)); err != nil {
+					session.FlashError(w, r, "Invite not found.")
+				} else if err := invite.Delete(); err != nil {
+					session.FlashError(w, r, "Couldn't revoke the invite: %s", err)
+				} else {
+					session.Flash(w, r, "The invite code %s has been revoked.", invite.Code)
+				}
+			default:
+				session.FlashError(w, r, "Unknown POST intent value. Please try again.")
+			}
+			templates.Redirect(w, r.URL.Path)
+			return
+		}
+
+		pager := &models.Pagination{
+			PerPage: config.PageSizeAdminInvites,
+			Sort:    "created_at desc",
+		}
+		pager.ParsePage(r)
+
+		inviteList, err := models.GetInvites(pager)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't list the invites: %s", err)
+		}
+
+		// Who made them, and who used them.
+		var inviteIDs, creatorIDs = []uint64{}, []uint64{}
+		for _, invite := range inviteList {
+			inviteIDs = append(inviteIDs, invite.ID)
+			creatorIDs = append(creatorIDs, invite.UserID)
+		}
+		creators, err := models.MapUsers(currentUser, creatorIDs)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't look up who made the invites: %s", err)
+		}
+		invitedUsers, err := models.MapInvitedUsers(inviteIDs)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't look up who used the invites: %s", err)
+		}
+
+		var vars = map[string]interface{}{
+			"Invites":         inviteList,
+			"Pager":           pager,
+			"Creators":        creators,
+			"InvitedUsers":    invitedUsers,
+			"UsesOptions":     usesWhitelist,
+			"ExpiresOptions":  expiresWhitelist,
+			"InvitesRequired": config.Current.Invites.Required,
+		}
+		if err := tmpl.Execute(w, r, vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// inWhitelist checks a form option against the allowed values.
+func inWhitelist(value int, whitelist []int) bool {
+	for _, v := range whitelist {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
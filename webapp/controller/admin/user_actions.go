@@ -1,15 +1,35 @@
 package admin
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
 	"github.com/aichaos/silhouette/webapp/models"
 	"github.com/aichaos/silhouette/webapp/models/deletion"
+	"github.com/aichaos/silhouette/webapp/router"
 	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/suspension"
 	"github.com/aichaos/silhouette/webapp/templates"
+	"github.com/aichaos/silhouette/webapp/utility"
 )
 
+// Lengths of suspension to choose from, in days. Zero is until an admin lifts it.
+var suspensionDays = []int{1, 3, 7, 14, 30, 0}
+
+// Permission needed for each user action intent.
+var intentPermissions = map[string]string{
+	"impersonate": models.PermissionUsersImpersonate,
+	"ban":         models.PermissionUsersBan,
+	"suspend":     models.PermissionUsersSuspend,
+	"roles":       models.PermissionUsersPromote,
+	"delete":      models.PermissionUsersDelete,
+}
+
 // Admin actions against a user account.
 func UserActions() http.HandlerFunc {
 	tmpl := templates.Must("admin/user_actions.html")
@@ -17,6 +37,7 @@ func UserActions() http.HandlerFunc {
 		var (
 			intent  = r.FormValue("intent")
 			confirm = r.Method == http.MethodPost
+			reason  = strings.TrimSpace(r.PostFormValue("reason"))
 			userId  uint64
 		)
 
@@ -36,33 +57,145 @@ func UserActions() http.HandlerFunc {
 			return
 		}
 
+		currentUser, err := session.CurrentUser(r)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't get CurrentUser: %s", err)
+			templates.Redirect(w, "/admin")
+			return
+		}
+
+		// May they do this to this user?
+		if permission, ok := intentPermissions[intent]; !ok {
+			session.FlashError(w, r, "Unsupported admin user intent: %s", intent)
+			templates.Redirect(w, "/admin")
+			return
+		} else if !currentUser.CanManage(user, permission) {
+			session.FlashError(w, r, "You do not have permission to do that to %s.", user.Username)
+			templates.Redirect(w, "/admin")
+			return
+		}
+
 		switch intent {
+		case "impersonate":
+			if confirm {
+				if reason == "" {
+					session.FlashError(w, r, "A reason is required to impersonate a user.")
+					templates.Redirect(w, fmt.Sprintf("%s?intent=impersonate&user_id=%d", r.URL.Path, user.ID))
+					return
+				}
+
+				// Don't impersonate yourself, or other admins.
+				if user.ID == currentUser.ID || user.IsAdmin {
+					session.FlashError(w, r, "You can not impersonate yourself or another admin.")
+					templates.Redirect(w, router.MustURLFor("profile", "username", user.Username))
+					return
+				}
+
+				if err := session.ImpersonateUser(w, r, user, currentUser, reason); err != nil {
+					session.FlashError(w, r, "Failed to impersonate user: %s", err)
+					templates.Redirect(w, "/admin")
+					return
+				}
+
+				session.Flash(w, r,
+					"You are now impersonating %s for up to %s. Use the banner at the top of the page to return to your own account.",
+					user.Username, utility.FormatDurationCoarse(config.ImpersonateExpires),
+				)
+				templates.Redirect(w, "/me")
+				return
+			}
 		case "ban":
 			if confirm {
-				status := r.PostFormValue("status")
-				if status == "active" {
-					user.Status = models.UserStatusActive
-				} else if status == "banned" {
-					user.Status = models.UserStatusBanned
+				var status = models.UserStatus(r.PostFormValue("status"))
+				if status != models.UserStatusActive && status != models.UserStatusBanned {
+					status = user.Status
 				}
 
-				user.Save()
-				session.Flash(w, r, "User ban status updated!")
-				templates.Redirect(w, "/u/"+user.Username)
+				// Only a banned user may be unbanned here: other statuses have their own way back
+				// (approval, reactivation, lifting a suspension, cancelling a deletion).
+				if status == models.UserStatusActive && user.Status != models.UserStatusBanned {
+					session.FlashError(w, r, "%s is not banned.", user.Username)
+				} else if err := setUserStatus(r, user, status, reason); err != nil {
+					session.FlashError(w, r, "Couldn't update the user's status: %s", err)
+				} else {
+					session.Flash(w, r, "User ban status updated!")
+				}
+				templates.Redirect(w, router.MustURLFor("profile", "username", user.Username))
 				return
 			}
-		case "promote":
+		case "suspend":
 			if confirm {
-				action := r.PostFormValue("action")
-				user.IsAdmin = action == "promote"
-				user.Save()
-				session.Flash(w, r, "User admin status updated!")
-				templates.Redirect(w, "/u/"+user.Username)
+				// Lifting a suspension early?
+				if r.PostFormValue("action") == "lift" {
+					if user.Status != models.UserStatusSuspended {
+						session.FlashError(w, r, "%s is not suspended.", user.Username)
+					} else if err := suspension.Reinstate(user); err != nil {
+						session.FlashError(w, r, "Couldn't lift the suspension: %s", err)
+					} else {
+						session.Audit(r, user, models.AuditLog{
+							Action: models.AuditReinstate,
+							Before: models.UserStatusSuspended,
+							After:  string(user.Status),
+							Reason: reason,
+						})
+						session.Flash(w, r, "The suspension of %s has been lifted.", user.Username)
+					}
+					templates.Redirect(w, router.MustURLFor("admin.user", "user_id", user.ID))
+					return
+				}
+
+				if reason == "" {
+					session.FlashError(w, r, "A reason is required to suspend a user. It will be shown to them.")
+					templates.Redirect(w, fmt.Sprintf("%s?intent=suspend&user_id=%d", r.URL.Path, user.ID))
+					return
+				}
+
+				if user.ID == currentUser.ID {
+					session.FlashError(w, r, "You can not suspend yourself.")
+					templates.Redirect(w, router.MustURLFor("admin.user", "user_id", user.ID))
+					return
+				}
+
+				var expiresAt time.Time
+				if days, err := strconv.Atoi(r.PostFormValue("days")); err == nil && days > 0 {
+					expiresAt = time.Now().AddDate(0, 0, days)
+				}
+
+				var oldStatus = user.Status
+				s, err := suspension.Suspend(user, currentUser, reason, expiresAt)
+				if err != nil {
+					session.FlashError(w, r, "Couldn't suspend the user: %s", err)
+				} else {
+					session.Audit(r, user, models.AuditLog{
+						Action: models.AuditSuspend,
+						Before: string(oldStatus),
+						After:  string(user.Status) + " " + suspension.Until(s),
+						Reason: reason,
+					})
+					session.Flash(w, r, "%s has been suspended %s.", user.Username, suspension.Until(s))
+				}
+				templates.Redirect(w, router.MustURLFor("admin.user", "user_id", user.ID))
+				return
+			}
+		case "roles":
+			if confirm {
+				if user.ID == currentUser.ID {
+					session.FlashError(w, r, "You can not change your own roles.")
+					templates.Redirect(w, router.MustURLFor("admin.user", "user_id", user.ID))
+					return
+				}
+
+				if err := setUserRoles(r, currentUser, user, r.PostForm["role"], reason); err != nil {
+					session.FlashError(w, r, "Couldn't update the user's roles: %s", err)
+				} else {
+					session.Flash(w, r, "User roles updated!")
+				}
+				templates.Redirect(w, router.MustURLFor("admin.user", "user_id", user.ID))
 				return
 			}
 		case "delete":
 			if confirm {
-				if err := deletion.DeleteUser(user); err != nil {
+				if err := deleteUser(r, user, reason); err != nil {
 					session.FlashError(w, r, "Failed when deleting the user: %s", err)
 				} else {
 					session.Flash(w, r, "User has been deleted!")
@@ -70,15 +203,30 @@ func UserActions() http.HandlerFunc {
 				templates.Redirect(w, "/admin")
 				return
 			}
-		default:
-			session.FlashError(w, r, "Unsupported admin user intent: %s", intent)
-			templates.Redirect(w, "/admin")
-			return
 		}
 
 		var vars = map[string]interface{}{
-			"Intent": intent,
-			"User":   user,
+			"Intent":             intent,
+			"User":               user,
+			"ImpersonateExpires": utility.FormatDurationCoarse(config.ImpersonateExpires),
+			"SuspensionDays":     suspensionDays,
+		}
+		if intent == "roles" {
+			roles, err := models.GetRoles()
+			if err != nil {
+				session.FlashError(w, r, "Couldn't get the roles: %s", err)
+			}
+			var grantable = map[string]bool{}
+			for _, role := range roles {
+				grantable[role.Name] = currentUser.CanGrant(role)
+			}
+			vars["Roles"] = roles
+			vars["UserRoles"] = userRoleNames(user)
+			vars["GrantableRoles"] = grantable
+		}
+		if s, err := models.GetSuspension(user.ID); err == nil && user.Status == models.UserStatusSuspended {
+			vars["Suspension"] = s
+			vars["SuspendedUntil"] = suspension.Until(s)
 		}
 		if err := tmpl.Execute(w, r, vars); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -86,3 +234,114 @@ func UserActions() http.HandlerFunc {
 		}
 	})
 }
+
+// setUserStatus bans or reinstates a user, recording it in the audit log. A banned user is signed
+// out everywhere.
+func setUserStatus(r *http.Request, user *models.User, status models.UserStatus, reason string) error {
+	if user.Status == status {
+		return nil
+	}
+
+	var oldStatus = user.Status
+	user.Status = status
+	if err := user.Save(); err != nil {
+		return err
+	}
+
+	// Banning or reinstating a suspended user replaces their suspension.
+	if oldStatus == models.UserStatusSuspended {
+		if err := models.DeleteSuspension(user.ID); err != nil {
+			log.Error("setUserStatus: couldn't remove suspension of %s: %s", user.Username, err)
+		}
+	}
+
+	session.Audit(r, user, models.AuditLog{
+		Action: models.AuditBan,
+		Before: string(oldStatus),
+		After:  string(user.Status),
+		Reason: reason,
+	})
+
+	// Kick a banned user out of all their sessions.
+	if user.Status == models.UserStatusBanned {
+		if err := session.RevokeAllSessions(user.ID, ""); err != nil {
+			log.Error("setUserStatus: couldn't revoke sessions for %s: %s", user.Username, err)
+		}
+	}
+	return nil
+}
+
+// setUserRoles replaces the roles of a user by name, recording it in the audit log. The admin may
+// only grant or revoke roles they could have been given themselves (see CanGrant).
+func setUserRoles(r *http.Request, currentUser, user *models.User, names []string, reason string) error {
+	before, err := user.Roles()
+	if err != nil {
+		return err
+	}
+
+	var (
+		roles   = []*models.Role{}
+		changed = map[string]*models.Role{}
+	)
+	for _, name := range names {
+		role, err := models.GetRole(name)
+		if err != nil {
+			return fmt.Errorf("no role named %s", name)
+		}
+		roles = append(roles, role)
+		changed[role.Name] = role
+	}
+	for _, role := range before {
+		if _, ok := changed[role.Name]; ok {
+			delete(changed, role.Name)
+		} else {
+			changed[role.Name] = role
+		}
+	}
+
+	for _, role := range changed {
+		if !currentUser.CanGrant(role) {
+			return fmt.Errorf("you may not grant or revoke the %s role", role.Name)
+		}
+	}
+
+	if err := user.SetRoles(roles); err != nil {
+		return err
+	}
+
+	if models.RoleNames(before) != models.RoleNames(roles) {
+		session.Audit(r, user, models.AuditLog{
+			Action: models.AuditPromote,
+			Before: models.RoleNames(before),
+			After:  models.RoleNames(roles),
+			Reason: reason,
+		})
+	}
+	return nil
+}
+
+// userRoleNames returns a set of the names of a user's roles.
+func userRoleNames(user *models.User) map[string]bool {
+	var result = map[string]bool{}
+	if roles, err := user.Roles(); err == nil {
+		for _, role := range roles {
+			result[role.Name] = true
+		}
+	} else {
+		log.Error("userRoleNames(%s): %s", user.Username, err)
+	}
+	return result
+}
+
+// deleteUser deep deletes a user account, recording it in the audit log.
+func deleteUser(r *http.Request, user *models.User, reason string) error {
+	if err := deletion.DeleteUser(user); err != nil {
+		return err
+	}
+
+	session.Audit(r, user, models.AuditLog{
+		Action: models.AuditAdminDelete,
+		Reason: reason,
+	})
+	return nil
+}
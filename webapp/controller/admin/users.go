@@ -0,0 +1,342 @@
+package admin
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/models"
+	passwd "github.com/aichaos/silhouette/webapp/password"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+// Bulk actions on the users console.
+var bulkActions = map[string]string{
+	"ban":    "Ban",
+	"unban":  "Unban",
+	"delete": "Delete",
+}
+
+// Permission needed for each bulk action.
+var bulkPermissions = map[string]string{
+	"ban":    models.PermissionUsersBan,
+	"unban":  models.PermissionUsersBan,
+	"delete": models.PermissionUsersDelete,
+}
+
+// Users console (/admin/users): search, filter and act on user accounts in bulk.
+func Users() http.HandlerFunc {
+	tmpl := templates.Must("admin/users.html")
+	confirmTmpl := templates.Must("admin/users_confirm.html")
+
+	// Whitelist for ordering options.
+	var sortWhitelist = []string{
+		"created_at desc",
+		"created_at",
+		"last_login_at desc",
+		"last_login_at",
+		"username",
+		"email",
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Bulk actions.
+		if r.Method == http.MethodPost {
+			var (
+				action  = r.PostFormValue("action")
+				confirm = r.PostFormValue("confirm") == "true"
+				reason  = strings.TrimSpace(r.PostFormValue("reason"))
+				userIDs = []uint64{}
+			)
+
+			if _, ok := bulkActions[action]; !ok {
+				session.FlashError(w, r, "Choose an action to take on the selected users.")
+				templates.Redirect(w, r.URL.Path)
+				return
+			}
+
+			currentUser, err := session.CurrentUser(r)
+			if err != nil {
+				session.FlashError(w, r, "Couldn't get CurrentUser: %s", err)
+				templates.Redirect(w, r.URL.Path)
+				return
+			}
+
+			if !currentUser.HasPermission(bulkPermissions[action]) {
+				session.FlashError(w, r, "You do not have permission to %s users.", action)
+				templates.Redirect(w, r.URL.Path)
+				return
+			}
+
+			// Collect the selected users, leaving out the admin themself.
+			for _, value := range r.PostForm["user_id"] {
+				if id, err := strconv.ParseUint(value, 10, 64); err == nil && id != currentUser.ID {
+					userIDs = append(userIDs, id)
+				}
+			}
+
+			selected, err := models.GetUsers(currentUser, userIDs)
+			if err != nil {
+				session.FlashError(w, r, "Couldn't get the selected users: %s", err)
+				templates.Redirect(w, r.URL.Path)
+				return
+			}
+
+			// And any staff they may not manage.
+			var users = []*models.User{}
+			for _, user := range selected {
+				if currentUser.CanManage(user, bulkPermissions[action]) {
+					users = append(users, user)
+				}
+			}
+			if len(users) == 0 {
+				session.FlashError(w, r, "No users were selected (you can't act on your own account, or staff you may not manage, here).")
+				templates.Redirect(w, r.URL.Path)
+				return
+			}
+
+			// Show what's about to happen before doing it.
+			if !confirm {
+				var vars = map[string]interface{}{
+					"Action":      action,
+					"ActionLabel": bulkActions[action],
+					"Users":       users,
+				}
+				if err := confirmTmpl.Execute(w, r, vars); err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+				}
+				return
+			}
+
+			var (
+				done    int
+				skipped = []string{}
+			)
+			for _, user := range users {
+				var err error
+				switch action {
+				case "ban":
+					err = setUserStatus(r, user, models.UserStatusBanned, reason)
+				case "unban":
+					// Other statuses have their own way back (approval, reactivation, etc.)
+					if user.Status != models.UserStatusBanned {
+						skipped = append(skipped, user.Username)
+						continue
+					}
+					err = setUserStatus(r, user, models.UserStatusActive, reason)
+				case "delete":
+					err = deleteUser(r, user, reason)
+				}
+
+				if err != nil {
+					log.Error("Users: bulk %s of %s failed: %s", action, user.Username, err)
+					session.FlashError(w, r, "Couldn't %s %s: %s", action, user.Username, err)
+				} else {
+					done++
+				}
+			}
+
+			session.Flash(w, r, "%s: done for %d user%s.", bulkActions[action], done, templates.Pluralize(done))
+			if len(skipped) > 0 {
+				session.FlashError(w, r, "Skipped because they are not banned: %s.", strings.Join(skipped, ", "))
+			}
+			templates.Redirect(w, r.URL.Path)
+			return
+		}
+
+		// Search filters.
+		var (
+			search = &models.UserSearch{
+				EmailOrUsername: r.FormValue("username"),
+				EmailDomain:     r.FormValue("domain"),
+			}
+			status = r.FormValue("status")
+			admin  = r.FormValue("admin")
+			sort   = r.FormValue("sort")
+			sortOK bool
+		)
+
+		switch status {
+		case models.UserStatusActive, models.UserStatusPending, models.UserStatusDeactivated, models.UserStatusPendingDeletion, models.UserStatusDisabled, models.UserStatusSuspended, models.UserStatusBanned:
+			search.Status = models.UserStatus(status)
+		default:
+			status = ""
+		}
+
+		switch admin {
+		case "yes", "no":
+			isAdmin := admin == "yes"
+			search.IsAdmin = &isAdmin
+		default:
+			admin = ""
+		}
+
+		var dates = map[string]string{}
+		for name, bound := range map[string]*time.Time{
+			"created_after":  &search.CreatedAfter,
+			"created_before": &search.CreatedBefore,
+			"login_after":    &search.LastLoginAfter,
+			"login_before":   &search.LastLoginBefore,
+		} {
+			*bound, dates[name] = parseDateFilter(w, r, name, strings.HasSuffix(name, "_before"))
+		}
+
+		// Sort options.
+		for _, v := range sortWhitelist {
+			if sort == v {
+				sortOK = true
+				break
+			}
+		}
+		if !sortOK {
+			sort = "created_at desc"
+		}
+
+		pager := &models.Pagination{
+			PerPage: config.PageSizeAdminUsers,
+			Sort:    sort,
+		}
+		pager.ParsePage(r)
+
+		users, err := models.SearchUsers(nil, search, pager)
+		if err != nil {
+			session.FlashError(w, r, "Couldn't search users: %s", err)
+		}
+
+		var vars = map[string]interface{}{
+			"Users":       users,
+			"Pager":       pager,
+			"BulkActions": bulkActions,
+
+			// Search filter values.
+			"EmailOrUsername": search.EmailOrUsername,
+			"EmailDomain":     search.EmailDomain,
+			"Status":          status,
+			"Admin":           admin,
+			"Dates":           dates,
+			"Sort":            sort,
+		}
+		if err := tmpl.Execute(w, r, vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// UserField is one column of a user row, for the user detail page.
+type UserField struct {
+	Name  string
+	Value interface{}
+}
+
+// UserDetail page (/admin/user?user_id=N) showing everything we have on an account.
+func UserDetail() http.HandlerFunc {
+	tmpl := templates.Must("admin/user_detail.html")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := strconv.ParseUint(r.FormValue("user_id"), 10, 64)
+		if err != nil {
+			session.FlashError(w, r, "Invalid or missing user_id parameter.")
+			templates.Redirect(w, "/admin/users")
+			return
+		}
+
+		user, err := models.GetUser(userID)
+		if err != nil {
+			session.FlashError(w, r, "Didn't find user ID in database: %s", err)
+			templates.Redirect(w, "/admin/users")
+			return
+		}
+
+		// Every column of the user row, so new ones show up here without more work.
+		var (
+			fields = []UserField{}
+			row    = reflect.ValueOf(*user)
+		)
+		for i := 0; i < row.NumField(); i++ {
+			if !row.Type().Field(i).IsExported() {
+				continue
+			}
+
+			var (
+				name  = row.Type().Field(i).Name
+				value = row.Field(i).Interface()
+			)
+			switch v := value.(type) {
+			case time.Time:
+				if v.IsZero() {
+					value = "never"
+				} else {
+					value = v.Format("Jan _2 2006 15:04:05 MST")
+				}
+			}
+
+			// Never show the password hash itself.
+			if name == "HashedPassword" {
+				value = passwd.Describe(user.HashedPassword)
+			}
+
+			fields = append(fields, UserField{name, value})
+		}
+
+		// Recent audit log entries on this account.
+		audit, err := models.SearchAuditLogs(&models.AuditSearch{TargetID: user.ID}, &models.Pagination{
+			Page:    1,
+			PerPage: config.PageSizeAdminUserAudit,
+		})
+		if err != nil {
+			log.Error("UserDetail: couldn't get audit log for %s: %s", user.Username, err)
+		}
+
+		var vars = map[string]interface{}{
+			"User":         user,
+			"Fields":       fields,
+			"HasTwoFactor": user.HasTwoFactor(),
+			"AuditLog":     audit,
+		}
+		if identities, err := models.GetExternalIdentities(user.ID); err == nil {
+			vars["ExternalIdentities"] = identities
+		}
+		if roles, err := user.Roles(); err == nil {
+			vars["Roles"] = roles
+		}
+		if user.InviteID > 0 {
+			if invite, err := models.GetInvite(user.InviteID); err == nil {
+				vars["Invite"] = invite
+				if inviter, err := models.GetUser(invite.UserID); err == nil {
+					vars["Inviter"] = inviter
+				}
+			}
+		}
+
+		if err := tmpl.Execute(w, r, vars); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+}
+
+// parseDateFilter reads a YYYY-MM-DD date from the form. Give endOfDay for the upper bound of a
+// range, so it takes in the whole of that day. Returns the zero time (and an empty string for the
+// form) if the date is missing or invalid.
+func parseDateFilter(w http.ResponseWriter, r *http.Request, name string, endOfDay bool) (time.Time, string) {
+	var value = strings.TrimSpace(r.FormValue(name))
+	if value == "" {
+		return time.Time{}, ""
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		session.FlashError(w, r, "Ignoring the date filter %q: it should look like YYYY-MM-DD.", value)
+		return time.Time{}, ""
+	}
+
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, value
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/router"
+	"github.com/aichaos/silhouette/webapp/session"
+)
+
+// UserProfile API returns a member's public profile (/v1/users/{username}).
+func UserProfile() http.HandlerFunc {
+	// Response JSON schema.
+	type Response struct {
+		OK          bool       `json:"OK"`
+		Error       string     `json:"error,omitempty"`
+		UserID      uint64     `json:"userId,omitempty"`
+		Username    string     `json:"username,omitempty"`
+		IsAdmin     bool       `json:"isAdmin,omitempty"`
+		CreatedAt   *time.Time `json:"createdAt,omitempty"`
+		LastLoginAt *time.Time `json:"lastLoginAt,omitempty"`
+		Status      string     `json:"status,omitempty"` // for admins only
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var username = router.Param(r, "username")
+
+		currentUser, err := session.CurrentUser(r)
+		if err != nil {
+			currentUser = nil
+		}
+
+		user, err := models.FindProfile(username, currentUser)
+		if err != nil {
+			SendJSON(w, http.StatusNotFound, Response{
+				Error: "user not found",
+			})
+			return
+		}
+
+		var res = Response{
+			OK:        true,
+			UserID:    user.ID,
+			Username:  user.Username,
+			IsAdmin:   user.IsAdmin,
+			CreatedAt: &user.CreatedAt,
+		}
+		if !user.LastLoginAt.IsZero() {
+			res.LastLoginAt = &user.LastLoginAt
+		}
+		if currentUser.HasPermission(models.PermissionUsersView) {
+			res.Status = string(user.Status)
+		}
+
+		SendJSON(w, http.StatusOK, res)
+	})
+}
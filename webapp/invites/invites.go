@@ -0,0 +1,88 @@
+// Package invites issues invite codes for invitation-only signup and emails them to people.
+package invites
+
+import (
+	"errors"
+	"fmt"
+	nm "net/mail"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/mail"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/router"
+)
+
+// CanInvite checks if the user may issue invites: staff with the users.invite permission, or any
+// member when the settings allow it.
+func CanInvite(user *models.User) bool {
+	if user == nil {
+		return false
+	}
+	return user.HasPermission(models.PermissionUsersInvite) || config.Current.Invites.MembersCanInvite
+}
+
+// Create an invite and, if an email address is given, send it there. Staff with the users.invite
+// permission choose the uses and expiration; members get a single use invite lasting the
+// configured time, and may only have so many unused ones at once. If only the email fails, the
+// invite is returned along with the error.
+func Create(user *models.User, maxUses int, expiresAt time.Time, email string) (*models.Invite, error) {
+	if !CanInvite(user) {
+		return nil, errors.New("you may not invite people")
+	}
+
+	if email != "" {
+		if _, err := nm.ParseAddress(email); err != nil {
+			return nil, fmt.Errorf("the email address is not valid: %s", err)
+		}
+	}
+
+	if !user.HasPermission(models.PermissionUsersInvite) {
+		count, err := models.CountUsableInvites(user.ID)
+		if err != nil {
+			return nil, err
+		} else if count >= int64(config.Current.Invites.MemberLimit) {
+			return nil, fmt.Errorf("you already have %d unused invites", count)
+		}
+
+		maxUses = 1
+		expiresAt = time.Time{}
+		if config.Current.Invites.MemberExpires > 0 {
+			expiresAt = time.Now().Add(config.Current.Invites.MemberExpires)
+		}
+	}
+
+	invite, err := models.CreateInvite(user, maxUses, expiresAt, email)
+	if err != nil {
+		return nil, err
+	}
+
+	if email != "" {
+		if err := Send(user, invite); err != nil {
+			return invite, err
+		}
+	}
+
+	return invite, nil
+}
+
+// Send an invite to the email address it was made for.
+func Send(inviter *models.User, invite *models.Invite) error {
+	return mail.Send(mail.Message{
+		To:       invite.Email,
+		Subject:  fmt.Sprintf("You're invited to join %s", config.Title),
+		Template: "email/invite.html",
+		Data: map[string]interface{}{
+			"Title":     config.Title,
+			"Inviter":   inviter.Username,
+			"Code":      invite.Code,
+			"URL":       URL(invite),
+			"ExpiresAt": invite.ExpiresAt,
+		},
+	})
+}
+
+// URL of the signup page with the invite code filled in.
+func URL(invite *models.Invite) string {
+	return router.MustAbsoluteURLFor("signup", "invite", invite.Code)
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/aichaos/silhouette/webapp/config"
 	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/router"
 	"github.com/microcosm-cc/bluemonday"
 	"gopkg.in/gomail.v2"
 )
@@ -40,7 +41,9 @@ func Send(msg Message) error {
 
 	// Get and render the template to HTML.
 	var html bytes.Buffer
-	tmpl, err := template.New(msg.Template).ParseFiles(config.TemplatePath + "/" + msg.Template)
+	tmpl, err := template.New(msg.Template).Funcs(template.FuncMap{
+		"AbsoluteURLFor": router.AbsoluteURLFor,
+	}).ParseFiles(config.TemplatePath + "/" + msg.Template)
 	if err != nil {
 		return err
 	}
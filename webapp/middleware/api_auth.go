@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/suspension"
+)
+
+// BearerToken returns the token from an "Authorization: Bearer" header, or blank.
+func BearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// APIAuth middleware for /v1 JSON endpoints. Requests with an "Authorization: Bearer" header are
+// authenticated by personal API token and the token's user is made the CurrentUser; other requests
+// fall through to the usual session cookie.
+func APIAuth(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		plaintext := BearerToken(r)
+		if plaintext == "" {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		token, err := models.FindAPIToken(plaintext)
+		if err != nil {
+			SendJSONError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		user, err := models.GetUser(token.UserID)
+		if err != nil {
+			log.Error("APIAuth: token %d belongs to a missing user %d: %s", token.ID, token.UserID, err)
+			SendJSONError(w, http.StatusUnauthorized, "not a valid API token")
+			return
+		}
+
+		// Are they suspended, banned or disabled?
+		if _, err := suspension.Check(user); err != nil {
+			log.Error("APIAuth: couldn't check suspension of %s: %s", user.Username, err)
+		}
+		if user.Status == models.UserStatusPending {
+			SendJSONError(w, http.StatusForbidden, "this account is awaiting approval")
+			return
+		} else if user.Status != models.UserStatusActive {
+			SendJSONError(w, http.StatusForbidden, "this account has been "+string(user.Status))
+			return
+		}
+
+		if err := token.Ping(); err != nil {
+			log.Error("APIAuth: couldn't ping LastUsedAt for token %d: %s", token.ID, err)
+		}
+
+		// Stick the CurrentUser and token in the request context.
+		ctx := context.WithValue(r.Context(), session.CurrentUserKey, user)
+		ctx = context.WithValue(ctx, session.APITokenKey, token)
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// APIScopeRequired middleware restricts an endpoint to API tokens that were granted the scope.
+// Requests authenticated by session cookie are not restricted by scope.
+func APIScopeRequired(scope string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := session.APIToken(r); ok && !token.HasScope(scope) {
+			SendJSONError(w, http.StatusForbidden, "this API token does not have the "+scope+" scope")
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// SendJSONError responds with an error in the standard JSON API envelope (see controller/api).
+func SendJSONError(w http.ResponseWriter, statusCode int, message string) {
+	buf, err := json.Marshal(map[string]interface{}{
+		"data": map[string]interface{}{
+			"OK":    false,
+			"error": message,
+		},
+		"StatusCode": statusCode,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(buf)
+}
@@ -3,13 +3,14 @@ package middleware
 import (
 	"context"
 	"net/http"
-	"net/url"
 	"time"
 
 	"github.com/aichaos/silhouette/webapp/config"
 	"github.com/aichaos/silhouette/webapp/log"
 	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/router"
 	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/suspension"
 	"github.com/aichaos/silhouette/webapp/templates"
 )
 
@@ -22,11 +23,22 @@ func LoginRequired(handler http.Handler) http.Handler {
 		if err != nil {
 			log.Error("LoginRequired: %s", err)
 			session.FlashError(w, r, "You must be signed in to view this page.")
-			templates.Redirect(w, "/login?next="+url.QueryEscape(r.URL.String()))
+			templates.Redirect(w, router.MustURLFor("login", "next", r.URL.String()))
 			return
 		}
 
-		// Are they banned or disabled?
+		// Are they suspended? (This reinstates them if it has run out.) An impersonating admin
+		// gets through, to be able to switch back.
+		if s, err := suspension.Check(user); err != nil {
+			log.Error("LoginRequired: couldn't check suspension of %s: %s", user.Username, err)
+		} else if s != nil && !session.Impersonated(r) {
+			session.LogoutUser(w, r)
+			session.FlashError(w, r, suspension.Message(s))
+			templates.Redirect(w, "/")
+			return
+		}
+
+		// Are they banned, disabled or deleting their account?
 		if user.Status == models.UserStatusDisabled {
 			session.LogoutUser(w, r)
 			session.FlashError(w, r, "Your account has been disabled and you are now logged out.")
@@ -37,6 +49,25 @@ func LoginRequired(handler http.Handler) http.Handler {
 			session.FlashError(w, r, "Your account has been banned and you are now logged out.")
 			templates.Redirect(w, "/")
 			return
+		} else if user.Status == models.UserStatusPendingDeletion && !session.Impersonated(r) {
+			session.LogoutUser(w, r)
+			session.FlashError(w, r, "Your account is closed pending deletion and you are now logged out.")
+			templates.Redirect(w, "/")
+			return
+		}
+
+		// Did they deactivate their account? Logging in again offers to reactivate it.
+		if user.Status == models.UserStatusDeactivated && !session.Impersonated(r) {
+			templates.Redirect(w, router.MustURLFor("account.reactivate", "next", r.URL.String()))
+			return
+		}
+
+		// Are they still waiting for an admin to approve their account? (An impersonating admin
+		// gets through, to be able to switch back.)
+		if user.Status == models.UserStatusPending && !session.Impersonated(r) {
+			ctx := context.WithValue(r.Context(), session.CurrentUserKey, user)
+			templates.PendingApprovalPage.ServeHTTP(w, r.WithContext(ctx))
+			return
 		}
 
 		// Ping LastLoginAt for long lived sessions, but not if impersonated.
@@ -53,7 +84,8 @@ func LoginRequired(handler http.Handler) http.Handler {
 	})
 }
 
-// AdminRequired middleware.
+// AdminRequired middleware lets in staff: users who have been granted any role. Use
+// PermissionRequired for what they may do.
 func AdminRequired(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
@@ -62,7 +94,7 @@ func AdminRequired(handler http.Handler) http.Handler {
 		if err != nil {
 			log.Error("AdminRequired: %s", err)
 			session.FlashError(w, r, "You must be signed in to view this page.")
-			templates.Redirect(w, "/login?next="+url.QueryEscape(r.URL.String()))
+			templates.Redirect(w, router.MustURLFor("login", "next", r.URL.String()))
 			return
 		}
 
@@ -80,3 +112,44 @@ func AdminRequired(handler http.Handler) http.Handler {
 		handler.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// PermissionRequired middleware lets in users whose roles grant the permission, e.g.
+// models.PermissionUsersBan.
+func PermissionRequired(permission string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// User must be logged in.
+		currentUser, err := session.CurrentUser(r)
+		if err != nil {
+			log.Error("PermissionRequired: %s", err)
+			session.FlashError(w, r, "You must be signed in to view this page.")
+			templates.Redirect(w, router.MustURLFor("login", "next", r.URL.String()))
+			return
+		}
+
+		// Stick the CurrentUser in the request context so future calls to session.CurrentUser can read it.
+		ctx := context.WithValue(r.Context(), session.CurrentUserKey, currentUser)
+
+		if !currentUser.HasPermission(permission) {
+			log.Error("PermissionRequired: %s lacks permission %s", currentUser.Username, permission)
+			templates.ForbiddenPage.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// NoImpersonation middleware blocks changes (non-GET requests) to an account while an admin is
+// impersonating it, for pages such as password changes and account deletion.
+func NoImpersonation(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && session.Impersonated(r) {
+			session.FlashError(w, r, "You can not make changes to this account while impersonating it.")
+			templates.Redirect(w, r.URL.Path)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
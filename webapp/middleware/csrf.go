@@ -3,6 +3,8 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/aichaos/silhouette/webapp/config"
@@ -18,8 +20,24 @@ func CSRF(handler http.Handler) http.Handler {
 		token := MakeCSRFCookie(r, w)
 		ctx := context.WithValue(r.Context(), session.CSRFKey, token)
 
-		// If it's a JSON post, allow it thru.
+		// Requests authenticated by API token carry no ambient browser credentials, so don't need
+		// CSRF protection. Give them a blank session so the cookie can't be used alongside the token.
+		if BearerToken(r) != "" {
+			ctx = context.WithValue(ctx, session.ContextKey, session.New())
+			handler.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		// JSON requests by session cookie: check the X-CSRF-Token header or a same-origin Origin.
 		if r.Header.Get("Content-Type") == "application/json" {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead && r.Method != http.MethodOptions {
+				if r.Header.Get(config.CSRFHeaderName) != token && !SameOrigin(r) {
+					log.Error("CSRF: cross-origin JSON request rejected (Origin=%s Referer=%s)", r.Header.Get("Origin"), r.Referer())
+					SendJSONError(w, http.StatusForbidden, "CSRF check failed: cross-origin request")
+					return
+				}
+			}
+
 			handler.ServeHTTP(w, r.WithContext(ctx))
 			return
 		}
@@ -43,6 +61,33 @@ func CSRF(handler http.Handler) http.Handler {
 	})
 }
 
+// SameOrigin checks that the Origin (or failing that, the Referer) header of a request points to this
+// website, either the Host it was requested on or the configured BaseURL.
+func SameOrigin(r *http.Request) bool {
+	var origin = r.Header.Get("Origin")
+	if origin == "" || origin == "null" {
+		origin = r.Referer()
+	}
+	if origin == "" {
+		return false
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	if base, err := url.Parse(config.Current.BaseURL); err == nil && strings.EqualFold(u.Host, base.Host) {
+		return true
+	}
+
+	return false
+}
+
 // MakeCSRFCookie gets or creates the CSRF cookie and returns its value.
 func MakeCSRFCookie(r *http.Request, w http.ResponseWriter) string {
 	// Has a token already?
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/pow"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+// ProofOfWork middleware requires the browser to have solved a challenge (pkg/pow) before a form
+// is posted. Put the ProofOfWork template function in the form. Controllers that only want it
+// for some of their posts can call pow.VerifyRequest themselves instead.
+func ProofOfWork(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		if err := pow.VerifyRequest(r); err != nil {
+			log.Error("ProofOfWork(%s): %s from %s", r.URL.Path, err, session.RemoteAddr(r))
+			session.FlashError(w, r, "Please try again: %s.", err)
+
+			// Back to the form, e.g. with its ?token= query string.
+			var next = r.URL.Path
+			if SameOrigin(r) && r.Referer() != "" {
+				next = r.Referer()
+			}
+			templates.Redirect(w, next)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
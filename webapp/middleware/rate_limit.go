@@ -0,0 +1,181 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/models/deletion"
+	"github.com/aichaos/silhouette/webapp/ratelimit"
+	"github.com/aichaos/silhouette/webapp/session"
+	"github.com/aichaos/silhouette/webapp/templates"
+	"github.com/aichaos/silhouette/webapp/utility"
+)
+
+// RateLimit middleware throttles a route by the named rule from settings.toml ([[RateLimit]]).
+//
+// Requests over the limit get a 429 with Retry-After: a JSON error for API and JSON requests, or
+// an error page for browsers. Every counted response carries the RateLimit-Limit, -Remaining and
+// -Reset headers. If the rule isn't configured, the route is not limited. If Redis is down the
+// request is let through, unless the rule is set to FailClosed.
+//
+// Rules keyed "by token" should go inside APIAuth so the API token is known.
+func RateLimit(name string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rule, ok := config.Current.GetRateLimitRule(name)
+		if !ok || !rateLimitMethod(rule, r.Method) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		limiter := &ratelimit.Limiter{
+			Namespace: "http/" + rule.Name,
+			ID:        rateLimitKey(r, rule.By),
+			Limit:     rule.Limit,
+			Window:    rule.Window,
+			Algorithm: ratelimit.Algorithm(rule.Algorithm),
+			OnError:   ratelimit.FailOpen,
+		}
+		if rule.FailClosed {
+			limiter.OnError = ratelimit.FailClosed
+		}
+
+		decision, err := limiter.Hit()
+		if err != nil {
+			log.Error("RateLimit(%s): %s", rule.Name, err)
+		}
+
+		// Redis is down and the rule fails closed.
+		if decision.Reason == ratelimit.ReasonError {
+			var message = "This service is temporarily unavailable. Please try again later."
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", ceilSeconds(decision.RetryAfter)))
+			if wantsJSON(r) {
+				SendJSONError(w, http.StatusServiceUnavailable, message)
+			} else {
+				templates.MakeErrorPage("Service Unavailable", message, http.StatusServiceUnavailable)(w, r)
+			}
+			return
+		}
+
+		w.Header().Set("RateLimit-Limit", fmt.Sprintf("%d", decision.Limit))
+		w.Header().Set("RateLimit-Remaining", fmt.Sprintf("%d", decision.Remaining))
+		w.Header().Set("RateLimit-Reset", fmt.Sprintf("%d", ceilSeconds(time.Until(decision.ResetAt))))
+
+		if !decision.Allowed {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", ceilSeconds(decision.RetryAfter)))
+
+			var message = fmt.Sprintf(
+				"You are doing that too often. Please wait %s before trying again.",
+				utility.FormatDurationCoarse(decision.RetryAfter),
+			)
+			if wantsJSON(r) {
+				SendJSONError(w, http.StatusTooManyRequests, message)
+			} else {
+				templates.MakeErrorPage("Slow down", message, http.StatusTooManyRequests)(w, r)
+			}
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitMethod checks if the rule counts requests of this method.
+func rateLimitMethod(rule config.RateLimitRule, method string) bool {
+	if len(rule.Methods) == 0 {
+		return true
+	}
+	for _, m := range rule.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitKey identifies who is making a request, by the rule's "by" setting.
+func rateLimitKey(r *http.Request, by string) string {
+	switch by {
+	case "token":
+		if token, ok := session.APIToken(r); ok {
+			return fmt.Sprintf("token:%d", token.ID)
+		}
+		fallthrough
+	case "user":
+		if user, err := session.CurrentUser(r); err == nil {
+			return fmt.Sprintf("user:%d", user.ID)
+		}
+	}
+	return "ip:" + session.RemoteAddr(r)
+}
+
+// userRateLimits are the limiters of the rules keyed by user or API token, for a deleted user.
+// The tokens are looked up in the database, so this must run before they are deleted.
+func userRateLimits(user *models.User) ([]*ratelimit.Limiter, error) {
+	tokens, err := models.GetAPITokens(user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var limiters = []*ratelimit.Limiter{}
+	for _, rule := range config.Current.RateLimit {
+		if rule.By != "user" && rule.By != "token" {
+			continue
+		}
+
+		var ids = []string{fmt.Sprintf("user:%d", user.ID)}
+		if rule.By == "token" {
+			for _, token := range tokens {
+				ids = append(ids, fmt.Sprintf("token:%d", token.ID))
+			}
+		}
+
+		for _, id := range ids {
+			limiters = append(limiters, &ratelimit.Limiter{
+				Namespace: "http/" + rule.Name,
+				ID:        id,
+			})
+		}
+	}
+	return limiters, nil
+}
+
+func init() {
+	deletion.RegisterCache(deletion.CacheStep{
+		Name: "HTTP rate limits",
+		Count: func(user *models.User) (int64, error) {
+			limiters, err := userRateLimits(user)
+			if err != nil {
+				return 0, err
+			}
+			return ratelimit.CountActive(limiters), nil
+		},
+		Prepare: func(user *models.User) (func() error, error) {
+			limiters, err := userRateLimits(user)
+			if err != nil {
+				return nil, err
+			}
+			return func() error {
+				return ratelimit.ClearAll(limiters)
+			}, nil
+		},
+	})
+}
+
+// wantsJSON says whether an error response should be JSON rather than an HTML page.
+func wantsJSON(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/v1/") ||
+		BearerToken(r) != "" ||
+		r.Header.Get("Content-Type") == "application/json" ||
+		strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+// ceilSeconds rounds a duration up to whole seconds, for headers.
+func ceilSeconds(d time.Duration) int64 {
+	return int64(math.Max(1, math.Ceil(d.Seconds())))
+}
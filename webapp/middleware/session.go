@@ -3,7 +3,10 @@ package middleware
 import (
 	"context"
 	"net/http"
+	"time"
 
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
 	"github.com/aichaos/silhouette/webapp/session"
 )
 
@@ -13,6 +16,20 @@ func Session(handler http.Handler) http.Handler {
 
 		// Check for the session_id cookie.
 		sess := session.LoadOrNew(r)
+
+		// Switch an admin back to their own account when their impersonation time is up.
+		if sess.ImpersonationExpired() {
+			sess.Errors = append(sess.Errors, "Your time impersonating that user is up; you are back on your own account.")
+			if _, err := sess.EndImpersonation(w); err != nil {
+				log.Error("Session: couldn't end an expired impersonation: %s", err)
+			}
+		}
+
+		// Refresh LastSeen on logged-in sessions every so often.
+		if sess.LoggedIn && time.Since(sess.LastSeen) > config.SessionTouchCooldown {
+			sess.Save(w)
+		}
+
 		ctx := context.WithValue(r.Context(), session.ContextKey, sess)
 
 		handler.ServeHTTP(w, r.WithContext(ctx))
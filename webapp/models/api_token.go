@@ -0,0 +1,159 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+)
+
+// APIToken table: personal access tokens for the /v1 JSON API.
+type APIToken struct {
+	ID          uint64 `gorm:"primaryKey"`
+	UserID      uint64 `gorm:"index"`
+	Name        string
+	HashedToken string    `gorm:"uniqueIndex"` // SHA-256 of the token; the plaintext is only shown once
+	Hint        string    // last few characters of the token, to help users tell them apart
+	Scopes      string    // space separated list of scopes
+	ExpiresAt   time.Time `gorm:"index"` // zero value = never expires
+	LastUsedAt  time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// APIScope describes a permission that can be granted to an API token.
+type APIScope struct {
+	Name        string
+	Description string
+}
+
+// API token scopes. Add new scopes here as new /v1 endpoints need them.
+const (
+	APIScopeAccountRead = "account:read"
+	APIScopeUsersRead   = "users:read"
+)
+
+// APIScopes available to personal API tokens, in the order shown on the settings page.
+var APIScopes = []APIScope{
+	{APIScopeAccountRead, "Read your basic account details (user ID and username)."},
+	{APIScopeUsersRead, "Look up other members' public profiles."},
+}
+
+// IsAPIScope checks whether a scope name is valid.
+func IsAPIScope(name string) bool {
+	for _, scope := range APIScopes {
+		if scope.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIToken issues a new personal API token. The plaintext token is returned only from
+// here; the database keeps just its hash. Give a zero expiresAt for a token that never expires.
+func CreateAPIToken(user *User, name string, scopes []string, expiresAt time.Time) (*APIToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", errors.New("a name for the token is required")
+	}
+
+	for _, scope := range scopes {
+		if !IsAPIScope(scope) {
+			return nil, "", fmt.Errorf("unknown scope: %s", scope)
+		}
+	}
+
+	var buf = make([]byte, config.APITokenSize)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	plaintext := config.APITokenPrefix + hex.EncodeToString(buf)
+
+	t := &APIToken{
+		UserID:      user.ID,
+		Name:        name,
+		HashedToken: hashAPIToken(plaintext),
+		Hint:        plaintext[len(plaintext)-4:],
+		Scopes:      strings.Join(scopes, " "),
+		ExpiresAt:   expiresAt,
+	}
+
+	result := DB.Create(t)
+	return t, plaintext, result.Error
+}
+
+// FindAPIToken looks up an unexpired API token by its plaintext value.
+func FindAPIToken(plaintext string) (*APIToken, error) {
+	if !strings.HasPrefix(plaintext, config.APITokenPrefix) {
+		return nil, errors.New("not a valid API token")
+	}
+
+	t := &APIToken{}
+	result := DB.Where("hashed_token = ?", hashAPIToken(plaintext)).First(t)
+	if result.Error != nil {
+		return nil, errors.New("not a valid API token")
+	}
+
+	if t.IsExpired() {
+		return nil, errors.New("this API token has expired")
+	}
+
+	return t, nil
+}
+
+// GetAPITokens returns all of a user's API tokens, newest first.
+func GetAPITokens(userID uint64) ([]*APIToken, error) {
+	var tokens = []*APIToken{}
+	result := DB.Where("user_id = ?", userID).Order("created_at desc").Find(&tokens)
+	return tokens, result.Error
+}
+
+// GetAPIToken gets a user's API token by ID.
+func GetAPIToken(userID, tokenID uint64) (*APIToken, error) {
+	t := &APIToken{}
+	result := DB.Where("id = ? AND user_id = ?", tokenID, userID).First(t)
+	return t, result.Error
+}
+
+// HasScope checks whether the token was granted a scope.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeList returns the token's scopes as a slice.
+func (t *APIToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+// IsExpired checks whether the token is past its expiration date.
+func (t *APIToken) IsExpired() bool {
+	return !t.ExpiresAt.IsZero() && time.Now().After(t.ExpiresAt)
+}
+
+// Ping the token's LastUsedAt time.
+func (t *APIToken) Ping() error {
+	t.LastUsedAt = time.Now()
+	return DB.Model(t).UpdateColumn("last_used_at", t.LastUsedAt).Error
+}
+
+// Delete (revoke) the API token.
+func (t *APIToken) Delete() error {
+	return DB.Delete(t).Error
+}
+
+// hashAPIToken hashes a plaintext token for storage. Tokens are long and random so a fast
+// hash is sufficient (unlike passwords).
+func hashAPIToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
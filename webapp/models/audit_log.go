@@ -0,0 +1,167 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// AuditLog table: a permanent record of admin actions and sensitive account events.
+//
+// Usernames are copied in at the time of the event so the record still reads correctly after
+// an account is renamed or deleted.
+type AuditLog struct {
+	ID             uint64      `gorm:"primaryKey"`
+	ActorID        uint64      `gorm:"index"` // who did it
+	ActorUsername  string      `gorm:"index"`
+	TargetID       uint64      `gorm:"index"` // whose account it was done to
+	TargetUsername string      `gorm:"index"`
+	Action         AuditAction `gorm:"index"`
+	Before         string      // the old value, if the action changed one
+	After          string      // the new value
+	IPAddress      string      `gorm:"index"`
+	Reason         string
+	CreatedAt      time.Time `gorm:"index"`
+}
+
+// AuditAction names an event in the audit log.
+type AuditAction string
+
+// Audit log actions. Add new ones to AuditActions too, so they can be filtered on.
+const (
+	AuditImpersonate      AuditAction = "admin.impersonate"
+	AuditUnimpersonate    AuditAction = "admin.unimpersonate"
+	AuditBan              AuditAction = "admin.ban"
+	AuditSuspend          AuditAction = "admin.suspend"
+	AuditReinstate        AuditAction = "admin.reinstate"
+	AuditPromote          AuditAction = "admin.promote"
+	AuditAdminDelete      AuditAction = "admin.delete"
+	AuditApprove          AuditAction = "admin.approve"
+	AuditReject           AuditAction = "admin.reject"
+	AuditCancelDeletion   AuditAction = "admin.deletion_cancel"
+	AuditPasswordChange   AuditAction = "account.password_change"
+	AuditPasswordReset    AuditAction = "account.password_reset"
+	AuditEmailChange      AuditAction = "account.email_change"
+	AuditTwoFactorEnable  AuditAction = "account.2fa_enable"
+	AuditTwoFactorDisable AuditAction = "account.2fa_disable"
+	AuditDelete           AuditAction = "account.delete"
+	AuditDeactivate       AuditAction = "account.deactivate"
+	AuditReactivate       AuditAction = "account.reactivate"
+	AuditDeletionRequest  AuditAction = "account.deletion_request"
+	AuditDeletionCancel   AuditAction = "account.deletion_cancel"
+	AuditDataExport       AuditAction = "account.data_export"
+
+	// Automatic events, with no actor.
+	AuditSuspensionExpired AuditAction = "account.suspension_expired"
+	AuditDeletionPurged    AuditAction = "account.deletion_purged"
+)
+
+// AuditActions in the order shown in the audit log viewer's filter.
+var AuditActions = []AuditAction{
+	AuditImpersonate,
+	AuditUnimpersonate,
+	AuditBan,
+	AuditSuspend,
+	AuditReinstate,
+	AuditPromote,
+	AuditAdminDelete,
+	AuditApprove,
+	AuditReject,
+	AuditCancelDeletion,
+	AuditPasswordChange,
+	AuditPasswordReset,
+	AuditEmailChange,
+	AuditTwoFactorEnable,
+	AuditTwoFactorDisable,
+	AuditDelete,
+	AuditDeactivate,
+	AuditReactivate,
+	AuditDeletionRequest,
+	AuditDeletionCancel,
+	AuditDataExport,
+	AuditSuspensionExpired,
+	AuditDeletionPurged,
+}
+
+// IsAdminAction is true for actions an admin took on somebody else's account.
+func (a AuditAction) IsAdminAction() bool {
+	return strings.HasPrefix(string(a), "admin.")
+}
+
+// CreateAuditLog writes an audit log entry.
+func CreateAuditLog(entry *AuditLog) error {
+	return DB.Create(entry).Error
+}
+
+// AuditSearch filters for the audit log.
+type AuditSearch struct {
+	Actor     string // username, or part of one
+	Target    string // username, or part of one
+	TargetID  uint64
+	Action    AuditAction
+	IPAddress string
+	Since     time.Time // zero for no bound
+	Until     time.Time
+}
+
+// SearchAuditLogs returns matching entries newest first, a page at a time. Give a nil pager to
+// get every matching entry, as for an export.
+func SearchAuditLogs(search *AuditSearch, pager *Pagination) ([]*AuditLog, error) {
+	if search == nil {
+		search = &AuditSearch{}
+	}
+
+	var (
+		entries      = []*AuditLog{}
+		wheres       = []string{}
+		placeholders = []interface{}{}
+	)
+
+	if search.Actor != "" {
+		wheres = append(wheres, "actor_username LIKE ?")
+		placeholders = append(placeholders, "%"+strings.TrimSpace(strings.ToLower(search.Actor))+"%")
+	}
+
+	if search.Target != "" {
+		wheres = append(wheres, "target_username LIKE ?")
+		placeholders = append(placeholders, "%"+strings.TrimSpace(strings.ToLower(search.Target))+"%")
+	}
+
+	if search.TargetID > 0 {
+		wheres = append(wheres, "target_id = ?")
+		placeholders = append(placeholders, search.TargetID)
+	}
+
+	if search.Action != "" {
+		wheres = append(wheres, "action = ?")
+		placeholders = append(placeholders, search.Action)
+	}
+
+	if search.IPAddress != "" {
+		wheres = append(wheres, "ip_address = ?")
+		placeholders = append(placeholders, strings.TrimSpace(search.IPAddress))
+	}
+
+	if !search.Since.IsZero() {
+		wheres = append(wheres, "created_at >= ?")
+		placeholders = append(placeholders, search.Since)
+	}
+
+	if !search.Until.IsZero() {
+		wheres = append(wheres, "created_at < ?")
+		placeholders = append(placeholders, search.Until)
+	}
+
+	query := DB.Model(&AuditLog{}).Where(
+		strings.Join(wheres, " AND "),
+		placeholders...,
+	).Order("created_at desc, id desc")
+
+	if pager == nil {
+		result := query.Find(&entries)
+		return entries, result.Error
+	}
+
+	query.Count(&pager.Total)
+	result := query.Offset(pager.GetOffset()).Limit(pager.PerPage).Find(&entries)
+	return entries, result.Error
+}
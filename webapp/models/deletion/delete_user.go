@@ -5,30 +5,86 @@ import (
 
 	"github.com/aichaos/silhouette/webapp/log"
 	"github.com/aichaos/silhouette/webapp/models"
+	"gorm.io/gorm"
 )
 
-// DeleteUser wipes a user and all associated data from the database.
+// Report of what deleting a user would remove, one line per step.
+type Report struct {
+	Step  string
+	Count int64
+}
+
+// DeleteUser wipes a user and all associated data.
+//
+// The registered steps and the user row are deleted in one transaction, so an error leaves the
+// account as it was. Redis cleanup happens afterwards: its errors are logged but don't stop the
+// other steps, as the account is already gone.
 func DeleteUser(user *models.User) error {
-	log.Error("BEGIN DeleteUser(%d, %s)", user.ID, user.Username)
+	log.Info("BEGIN DeleteUser(%d, %s)", user.ID, user.Username)
+
+	// Look up what the Redis cleanup needs while the rows are still there.
+	var cleanups = make([]func() error, len(cacheSteps))
+	for i, step := range cacheSteps {
+		if step.Prepare == nil {
+			continue
+		}
+
+		cleanup, err := step.Prepare(user)
+		if err != nil {
+			return fmt.Errorf("%s: %s", step.Name, err)
+		}
+		cleanups[i] = cleanup
+	}
+
+	err := models.DB.Transaction(func(tx *gorm.DB) error {
+		for _, step := range steps {
+			if err := step.Delete(tx, user.ID); err != nil {
+				return fmt.Errorf("%s: %s", step.Name, err)
+			}
+		}
 
-	// Remove all linked tables and assets.
-	type remover struct {
-		Step string
-		Fn   func(uint64) error
+		// Remove the user itself.
+		return tx.Delete(user).Error
+	})
+	if err != nil {
+		return err
 	}
 
-	var todo = []remover{
-		// e.g.
-		// {"Notifications", func(userID uint64) error},
-		// {"Likes", DeleteLikes},
-		// {"Threads", DeleteForumThreads},
+	for i, step := range cacheSteps {
+		var err error
+		if cleanups[i] != nil {
+			err = cleanups[i]()
+		} else {
+			err = step.Delete(user)
+		}
+		if err != nil {
+			log.Error("DeleteUser(%s): %s: %s", user.Username, step.Name, err)
+		}
 	}
-	for _, item := range todo {
-		if err := item.Fn(user.ID); err != nil {
-			return fmt.Errorf("%s: %s", item.Step, err)
+
+	return nil
+}
+
+// DryRun counts what DeleteUser would remove for a user, without deleting anything.
+func DryRun(user *models.User) ([]Report, error) {
+	var report = []Report{}
+
+	for _, step := range steps {
+		count, err := step.Count(models.DB, user.ID)
+		if err != nil {
+			return report, fmt.Errorf("%s: %s", step.Name, err)
+		}
+		report = append(report, Report{step.Name, count})
+	}
+	report = append(report, Report{"User account", 1})
+
+	for _, step := range cacheSteps {
+		count, err := step.Count(user)
+		if err != nil {
+			return report, fmt.Errorf("%s: %s", step.Name, err)
 		}
+		report = append(report, Report{step.Name, count})
 	}
 
-	// Remove the user itself.
-	return user.Delete()
+	return report, nil
 }
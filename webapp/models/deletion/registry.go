@@ -0,0 +1,80 @@
+package deletion
+
+import (
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/redis"
+	"gorm.io/gorm"
+)
+
+// Step removes one kind of a user's data from the database, as part of the transaction which
+// deletes their account.
+type Step struct {
+	Name   string
+	Count  func(tx *gorm.DB, userID uint64) (int64, error) // rows it would remove, for dry runs
+	Delete func(tx *gorm.DB, userID uint64) error
+}
+
+// CacheStep removes a user's data from Redis (sessions, rate limits, pending tokens...) after
+// their account is gone from the database.
+type CacheStep struct {
+	Name   string
+	Count  func(user *models.User) (int64, error)
+	Delete func(user *models.User) error
+
+	// Prepare is for steps that need to look in the database to know what to remove, e.g. the IDs
+	// of the user's API tokens. If set, it runs before the user's rows are deleted and returns
+	// the cleanup to run afterwards, in place of Delete.
+	Prepare func(user *models.User) (func() error, error)
+}
+
+// The steps registered so far, in order.
+var (
+	steps      []Step
+	cacheSteps []CacheStep
+)
+
+// Register a step for DeleteUser. Packages which store data about users call it from init.
+func Register(step Step) {
+	steps = append(steps, step)
+}
+
+// RegisterCache registers a Redis cleanup step for DeleteUser.
+func RegisterCache(step CacheStep) {
+	cacheSteps = append(cacheSteps, step)
+}
+
+// Table makes a Step that removes the rows of a model whose user_id is the user's.
+func Table(name string, model interface{}) Step {
+	return Step{
+		Name: name,
+		Count: func(tx *gorm.DB, userID uint64) (int64, error) {
+			var count int64
+			err := tx.Model(model).Where("user_id = ?", userID).Count(&count).Error
+			return count, err
+		},
+		Delete: func(tx *gorm.DB, userID uint64) error {
+			return tx.Where("user_id = ?", userID).Delete(model).Error
+		},
+	}
+}
+
+// The tables of the models package.
+func init() {
+	Register(Table("Two-factor", &models.TwoFactor{}))
+	Register(Table("API tokens", &models.APIToken{}))
+	Register(Table("External logins", &models.ExternalIdentity{}))
+	Register(Table("Suspension", &models.Suspension{}))
+	Register(Table("Roles", &models.UserRole{}))
+	Register(Table("Invites", &models.Invite{}))
+	Register(Table("Deletion request", &models.DeletionRequest{}))
+
+	RegisterCache(CacheStep{
+		Name: "Pending tokens",
+		Count: func(user *models.User) (int64, error) {
+			return int64(len(redis.UserTokens(user.ID))), nil
+		},
+		Delete: func(user *models.User) error {
+			return redis.DeleteUserTokens(user.ID)
+		},
+	})
+}
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+)
+
+// DeletionRequest table: a user's request to delete their account, which is carried out when the
+// grace period runs out unless they cancel it first. There is at most one per user.
+type DeletionRequest struct {
+	ID        uint64    `gorm:"primaryKey"`
+	UserID    uint64    `gorm:"uniqueIndex"`
+	PurgeAt   time.Time `gorm:"index"` // when the account is deleted for good
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// GetDeletionRequest looks up the deletion request of a user ID.
+func GetDeletionRequest(userID uint64) (*DeletionRequest, error) {
+	d := &DeletionRequest{}
+	result := DB.Where("user_id = ?", userID).First(d)
+	return d, result.Error
+}
+
+// GetDeletionRequests returns the pending deletions soonest first, a page at a time.
+func GetDeletionRequests(pager *Pagination) ([]*DeletionRequest, error) {
+	var (
+		requests = []*DeletionRequest{}
+		query    = DB.Model(&DeletionRequest{}).Order("purge_at, id")
+	)
+
+	query.Count(&pager.Total)
+	result := query.Offset(pager.GetOffset()).Limit(pager.PerPage).Find(&requests)
+	return requests, result.Error
+}
+
+// GetDueDeletionRequests returns the deletion requests whose grace period has run out.
+func GetDueDeletionRequests() ([]*DeletionRequest, error) {
+	var requests = []*DeletionRequest{}
+	result := DB.Where("purge_at <= ?", time.Now()).Order("purge_at, id").Find(&requests)
+	return requests, result.Error
+}
+
+// CountDeletionRequests counts the accounts pending deletion.
+func CountDeletionRequests() (int64, error) {
+	var count int64
+	result := DB.Model(&DeletionRequest{}).Count(&count)
+	return count, result.Error
+}
+
+// RequestDeletion marks the user's account pending deletion, to be purged at the given time.
+func (u *User) RequestDeletion(purgeAt time.Time) (*DeletionRequest, error) {
+	d, err := GetDeletionRequest(u.ID)
+	if err != nil {
+		d = &DeletionRequest{
+			UserID: u.ID,
+		}
+	}
+	d.PurgeAt = purgeAt
+	if err := DB.Save(d).Error; err != nil {
+		return nil, err
+	}
+
+	u.Status = UserStatusPendingDeletion
+	return d, u.Save()
+}
+
+// CancelDeletion withdraws the user's deletion request and makes their account active again.
+func (u *User) CancelDeletion() error {
+	if err := DeleteDeletionRequest(u.ID); err != nil {
+		return err
+	}
+	u.Status = UserStatusActive
+	return u.Save()
+}
+
+// DeleteDeletionRequest removes the deletion request of a user ID, if any.
+func DeleteDeletionRequest(userID uint64) error {
+	return DB.Where("user_id = ?", userID).Delete(&DeletionRequest{}).Error
+}
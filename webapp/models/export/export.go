@@ -0,0 +1,68 @@
+// Package export gathers everything we store about a user into a ZIP file of JSON documents, for
+// them to download (GDPR/CCPA data requests).
+//
+// Like the deletion steps (see pkg/models/deletion), packages which store data about users
+// register an Exporter for it from init. Exporters must leave out secrets such as password hashes,
+// token hashes and two-factor keys.
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/models"
+)
+
+// Exporter contributes one JSON file to a user's data export.
+type Exporter struct {
+	Name   string                                       // file name in the ZIP, without .json
+	Export func(user *models.User) (interface{}, error) // nil for no file
+}
+
+// The exporters registered so far, in order.
+var exporters []Exporter
+
+// Register an exporter. Packages which store data about users call it from init.
+func Register(exporter Exporter) {
+	exporters = append(exporters, exporter)
+}
+
+// Build the ZIP file of a user's data.
+func Build(user *models.User) ([]byte, error) {
+	log.Info("BEGIN export.Build(%d, %s)", user.ID, user.Username)
+
+	var (
+		buf bytes.Buffer
+		zw  = zip.NewWriter(&buf)
+	)
+
+	for _, exporter := range exporters {
+		data, err := exporter.Export(user)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", exporter.Name, err)
+		} else if data == nil {
+			continue
+		}
+
+		bin, err := json.MarshalIndent(data, "", "    ")
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", exporter.Name, err)
+		}
+
+		f, err := zw.Create(exporter.Name + ".json")
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(bin); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,227 @@
+package export
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/models"
+	"gorm.io/gorm"
+)
+
+// The JSON documents for the tables of the models package. They copy only what is safe to
+// give back to the user: no password, token or recovery code hashes.
+type (
+	account struct {
+		ID          uint64
+		Username    string
+		Email       string
+		Status      models.UserStatus
+		CreatedAt   time.Time
+		UpdatedAt   time.Time
+		LastLoginAt time.Time
+	}
+
+	twoFactor struct {
+		Enabled                bool
+		RecoveryCodesRemaining int
+		CreatedAt              time.Time
+	}
+
+	apiToken struct {
+		Name       string
+		Hint       string
+		Scopes     []string
+		ExpiresAt  time.Time
+		LastUsedAt time.Time
+		CreatedAt  time.Time
+	}
+
+	externalLogin struct {
+		Provider  string
+		Subject   string
+		Email     string
+		CreatedAt time.Time
+	}
+
+	suspension struct {
+		Reason    string
+		ExpiresAt time.Time
+		CreatedAt time.Time
+	}
+
+	invite struct {
+		Code      string
+		Email     string
+		MaxUses   int
+		Uses      int
+		ExpiresAt time.Time
+		CreatedAt time.Time
+	}
+
+	deletionRequest struct {
+		PurgeAt   time.Time
+		CreatedAt time.Time
+	}
+
+	auditEntry struct {
+		Action    models.AuditAction
+		Before    string
+		After     string
+		IPAddress string
+		Reason    string
+		CreatedAt time.Time
+	}
+)
+
+// notFound turns a missing one-to-one record into no file.
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+func init() {
+	Register(Exporter{"account", func(user *models.User) (interface{}, error) {
+		return account{
+			ID:          user.ID,
+			Username:    user.Username,
+			Email:       user.Email,
+			Status:      user.Status,
+			CreatedAt:   user.CreatedAt,
+			UpdatedAt:   user.UpdatedAt,
+			LastLoginAt: user.LastLoginAt,
+		}, nil
+	}})
+
+	Register(Exporter{"roles", func(user *models.User) (interface{}, error) {
+		roles, err := user.Roles()
+		if err != nil {
+			return nil, err
+		}
+
+		var names = []string{}
+		for _, role := range roles {
+			names = append(names, role.Name)
+		}
+		return names, nil
+	}})
+
+	Register(Exporter{"two_factor", func(user *models.User) (interface{}, error) {
+		tf, err := models.GetTwoFactor(user.ID)
+		if err != nil {
+			return nil, notFound(err)
+		}
+		return twoFactor{
+			Enabled:                tf.Enabled,
+			RecoveryCodesRemaining: tf.RecoveryCodesRemaining(),
+			CreatedAt:              tf.CreatedAt,
+		}, nil
+	}})
+
+	Register(Exporter{"api_tokens", func(user *models.User) (interface{}, error) {
+		tokens, err := models.GetAPITokens(user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		var result = []apiToken{}
+		for _, t := range tokens {
+			result = append(result, apiToken{
+				Name:       t.Name,
+				Hint:       t.Hint,
+				Scopes:     t.ScopeList(),
+				ExpiresAt:  t.ExpiresAt,
+				LastUsedAt: t.LastUsedAt,
+				CreatedAt:  t.CreatedAt,
+			})
+		}
+		return result, nil
+	}})
+
+	Register(Exporter{"external_logins", func(user *models.User) (interface{}, error) {
+		identities, err := models.GetExternalIdentities(user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		var result = []externalLogin{}
+		for _, ei := range identities {
+			result = append(result, externalLogin{
+				Provider:  ei.Provider,
+				Subject:   ei.Subject,
+				Email:     ei.Email,
+				CreatedAt: ei.CreatedAt,
+			})
+		}
+		return result, nil
+	}})
+
+	Register(Exporter{"suspension", func(user *models.User) (interface{}, error) {
+		s, err := models.GetSuspension(user.ID)
+		if err != nil {
+			return nil, notFound(err)
+		}
+		return suspension{
+			Reason:    s.Reason,
+			ExpiresAt: s.ExpiresAt,
+			CreatedAt: s.CreatedAt,
+		}, nil
+	}})
+
+	Register(Exporter{"invites", func(user *models.User) (interface{}, error) {
+		invites, err := models.GetUserInvites(user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		var result = []invite{}
+		for _, i := range invites {
+			result = append(result, invite{
+				Code:      i.Code,
+				Email:     i.Email,
+				MaxUses:   i.MaxUses,
+				Uses:      i.Uses,
+				ExpiresAt: i.ExpiresAt,
+				CreatedAt: i.CreatedAt,
+			})
+		}
+		return result, nil
+	}})
+
+	Register(Exporter{"deletion_request", func(user *models.User) (interface{}, error) {
+		d, err := models.GetDeletionRequest(user.ID)
+		if err != nil {
+			return nil, notFound(err)
+		}
+		return deletionRequest{
+			PurgeAt:   d.PurgeAt,
+			CreatedAt: d.CreatedAt,
+		}, nil
+	}})
+
+	// Events about their account, but not which admin did them: the IP address is the actor's,
+	// so it's only given for the user's own actions.
+	Register(Exporter{"audit_log", func(user *models.User) (interface{}, error) {
+		entries, err := models.SearchAuditLogs(&models.AuditSearch{TargetID: user.ID}, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		var result = []auditEntry{}
+		for _, e := range entries {
+			entry := auditEntry{
+				Action:    e.Action,
+				Before:    e.Before,
+				After:     e.After,
+				Reason:    e.Reason,
+				CreatedAt: e.CreatedAt,
+			}
+			if e.ActorID == user.ID {
+				entry.IPAddress = e.IPAddress
+			}
+			result = append(result, entry)
+		}
+		return result, nil
+	}})
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+)
+
+// ExternalIdentity table links a user account to a login with an OpenID Connect provider.
+type ExternalIdentity struct {
+	ID        uint64 `gorm:"primaryKey"`
+	UserID    uint64 `gorm:"index"`
+	Provider  string `gorm:"uniqueIndex:idx_external_identity"` // provider Name from settings.toml
+	Subject   string `gorm:"uniqueIndex:idx_external_identity"` // the provider's stable user ID ("sub" claim)
+	Email     string // email address at the provider, for display
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// FindExternalIdentity looks up the link for a provider's subject.
+func FindExternalIdentity(provider, subject string) (*ExternalIdentity, error) {
+	ei := &ExternalIdentity{}
+	result := DB.Where("provider = ? AND subject = ?", provider, subject).First(ei)
+	return ei, result.Error
+}
+
+// GetExternalIdentities returns all of the external logins linked to a user.
+func GetExternalIdentities(userID uint64) ([]*ExternalIdentity, error) {
+	var identities = []*ExternalIdentity{}
+	result := DB.Where("user_id = ?", userID).Order("provider").Find(&identities)
+	return identities, result.Error
+}
+
+// GetExternalIdentity gets one of a user's external logins by ID.
+func GetExternalIdentity(userID, id uint64) (*ExternalIdentity, error) {
+	ei := &ExternalIdentity{}
+	result := DB.Where("id = ? AND user_id = ?", id, userID).First(ei)
+	return ei, result.Error
+}
+
+// LinkExternalIdentity links a provider's subject to a user account.
+func LinkExternalIdentity(user *User, provider, subject, email string) (*ExternalIdentity, error) {
+	ei := &ExternalIdentity{
+		UserID:   user.ID,
+		Provider: provider,
+		Subject:  subject,
+		Email:    email,
+	}
+	result := DB.Create(ei)
+	return ei, result.Error
+}
+
+// Delete (unlink) the external identity.
+func (ei *ExternalIdentity) Delete() error {
+	return DB.Delete(ei).Error
+}
@@ -0,0 +1,193 @@
+package models
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"gorm.io/gorm"
+)
+
+// Invite table: a code that lets people sign up when signup is invitation-only.
+type Invite struct {
+	ID        uint64 `gorm:"primaryKey"`
+	Code      string `gorm:"uniqueIndex"`
+	UserID    uint64 `gorm:"index"` // who created it
+	Email     string // who it was emailed to, if anyone
+	MaxUses   int    // zero = unlimited
+	Uses      int
+	ExpiresAt time.Time `gorm:"index"` // zero value = never expires
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// CreateInvite issues a new invite code. Give a zero maxUses for unlimited uses and a zero
+// expiresAt for an invite that never expires.
+func CreateInvite(creator *User, maxUses int, expiresAt time.Time, email string) (*Invite, error) {
+	if maxUses < 0 {
+		return nil, errors.New("the number of uses can not be negative")
+	}
+
+	code, err := newInviteCode()
+	if err != nil {
+		return nil, err
+	}
+
+	i := &Invite{
+		Code:      code,
+		UserID:    creator.ID,
+		Email:     strings.TrimSpace(strings.ToLower(email)),
+		MaxUses:   maxUses,
+		ExpiresAt: expiresAt,
+	}
+
+	result := DB.Create(i)
+	return i, result.Error
+}
+
+// FindInvite looks up an invite by its code, and checks that it can still be used.
+func FindInvite(code string) (*Invite, error) {
+	code = NormalizeInviteCode(code)
+	if code == "" {
+		return nil, errors.New("an invite code is required to sign up")
+	}
+
+	i := &Invite{}
+	if result := DB.Where("code = ?", code).First(i); result.Error != nil {
+		return nil, errors.New("that invite code is not valid")
+	}
+
+	if i.IsExpired() {
+		return nil, errors.New("that invite code has expired")
+	} else if i.IsUsedUp() {
+		return nil, errors.New("that invite code has already been used")
+	}
+
+	return i, nil
+}
+
+// GetInvite by ID.
+func GetInvite(id uint64) (*Invite, error) {
+	i := &Invite{}
+	result := DB.First(i, id)
+	return i, result.Error
+}
+
+// GetInvites returns all invites newest first, a page at a time.
+func GetInvites(pager *Pagination) ([]*Invite, error) {
+	var (
+		invites = []*Invite{}
+		query   = DB.Model(&Invite{}).Order("created_at desc, id desc")
+	)
+
+	query.Count(&pager.Total)
+	result := query.Offset(pager.GetOffset()).Limit(pager.PerPage).Find(&invites)
+	return invites, result.Error
+}
+
+// GetUserInvites returns the invites a user has created, newest first.
+func GetUserInvites(userID uint64) ([]*Invite, error) {
+	var invites = []*Invite{}
+	result := DB.Where("user_id = ?", userID).Order("created_at desc, id desc").Find(&invites)
+	return invites, result.Error
+}
+
+// CountUsableInvites counts a user's invites that are neither expired nor used up.
+func CountUsableInvites(userID uint64) (int64, error) {
+	var count int64
+	result := DB.Model(&Invite{}).
+		Where("user_id = ? AND (max_uses = 0 OR uses < max_uses) AND (expires_at = ? OR expires_at > ?)",
+			userID, time.Time{}, time.Now(),
+		).
+		Count(&count)
+	return count, result.Error
+}
+
+// MapInvitedUsers looks up the users who signed up with each of a set of invites, by username.
+func MapInvitedUsers(inviteIDs []uint64) (map[uint64][]*User, error) {
+	var (
+		result = map[uint64][]*User{}
+		users  = []*User{}
+	)
+	if len(inviteIDs) == 0 {
+		return result, nil
+	}
+
+	if err := DB.Where("invite_id IN ?", inviteIDs).Order("username").Find(&users).Error; err != nil {
+		return result, err
+	}
+	for _, user := range users {
+		result[user.InviteID] = append(result[user.InviteID], user)
+	}
+	return result, nil
+}
+
+// IsExpired checks whether the invite is past its expiration date.
+func (i *Invite) IsExpired() bool {
+	return !i.ExpiresAt.IsZero() && time.Now().After(i.ExpiresAt)
+}
+
+// IsUsedUp checks whether the invite has no uses left.
+func (i *Invite) IsUsedUp() bool {
+	return i.MaxUses > 0 && i.Uses >= i.MaxUses
+}
+
+// Claim one of the invite's uses for a new signup. It fails if somebody else took the last one
+// in the meantime.
+func (i *Invite) Claim() error {
+	result := DB.Model(&Invite{}).
+		Where("id = ? AND (max_uses = 0 OR uses < max_uses)", i.ID).
+		UpdateColumn("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return result.Error
+	} else if result.RowsAffected == 0 {
+		return errors.New("that invite code has already been used")
+	}
+	i.Uses++
+	return nil
+}
+
+// Release a use claimed for a signup that then failed.
+func (i *Invite) Release() error {
+	result := DB.Model(&Invite{}).
+		Where("id = ? AND uses > 0", i.ID).
+		UpdateColumn("uses", gorm.Expr("uses - 1"))
+	if result.Error == nil && i.Uses > 0 {
+		i.Uses--
+	}
+	return result.Error
+}
+
+// Delete (revoke) the invite. Accounts created with it keep their InviteID.
+func (i *Invite) Delete() error {
+	return DB.Delete(i).Error
+}
+
+// NormalizeInviteCode uppercases a code as typed by a user and drops spaces and dashes.
+func NormalizeInviteCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(code)))
+}
+
+// newInviteCode makes a random invite code that is easy to read out or type.
+func newInviteCode() (string, error) {
+	var (
+		code = make([]byte, config.InviteCodeLength)
+		max  = big.NewInt(int64(len(config.InviteCodeAlphabet)))
+	)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = config.InviteCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
@@ -1,12 +1,31 @@
 // Package models handles the database.
 package models
 
-import "gorm.io/gorm"
+import (
+	"github.com/aichaos/silhouette/webapp/log"
+	"gorm.io/gorm"
+)
 
 // DB to be set by calling app (SQLite or Postgres connection).
 var DB *gorm.DB
 
 // AutoMigrate the schema. List all your new models here for DB creation.
 func AutoMigrate() {
-	DB.AutoMigrate(&User{})
+	DB.AutoMigrate(
+		&User{},
+		&TwoFactor{},
+		&APIToken{},
+		&ExternalIdentity{},
+		&AuditLog{},
+		&Suspension{},
+		&Role{},
+		&Permission{},
+		&UserRole{},
+		&Invite{},
+		&DeletionRequest{},
+	)
+
+	if err := SeedRoles(); err != nil {
+		log.Error("AutoMigrate: couldn't set up the roles: %s", err)
+	}
 }
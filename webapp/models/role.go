@@ -0,0 +1,327 @@
+package models
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/log"
+)
+
+// Role table: a named set of permissions that can be granted to users, e.g. "moderator".
+type Role struct {
+	ID          uint64 `gorm:"primaryKey"`
+	Name        string `gorm:"uniqueIndex"`
+	Description string
+	Permissions []Permission `gorm:"many2many:role_permissions"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Permission table: one thing a role allows, e.g. "users.ban". The rows are kept in sync with
+// the Permissions list below at startup.
+type Permission struct {
+	ID          uint64 `gorm:"primaryKey"`
+	Name        string `gorm:"uniqueIndex"`
+	Description string
+}
+
+// UserRole table: the roles granted to each user.
+type UserRole struct {
+	UserID    uint64 `gorm:"primaryKey"`
+	RoleID    uint64 `gorm:"primaryKey;index"`
+	CreatedAt time.Time
+}
+
+// Permission names. Add new ones here and to Permissions; the superuser role gets them all.
+const (
+	PermissionUsersView        = "users.view"        // admin users console, account details
+	PermissionUsersBan         = "users.ban"         // ban and unban
+	PermissionUsersSuspend     = "users.suspend"     // suspend and lift suspensions
+	PermissionUsersImpersonate = "users.impersonate" // log in as a user
+	PermissionUsersPromote     = "users.promote"     // grant and revoke roles
+	PermissionUsersDelete      = "users.delete"      // delete accounts
+	PermissionUsersInvite      = "users.invite"      // issue invite codes
+	PermissionUsersApprove     = "users.approve"     // approve or reject new accounts
+	PermissionAuditView        = "audit.view"        // the audit log
+)
+
+// Permissions there are, in the order shown to admins.
+var Permissions = []Permission{
+	{Name: PermissionUsersView, Description: "View the users console and account details."},
+	{Name: PermissionUsersBan, Description: "Ban and unban users."},
+	{Name: PermissionUsersSuspend, Description: "Suspend users and lift suspensions."},
+	{Name: PermissionUsersImpersonate, Description: "Impersonate users."},
+	{Name: PermissionUsersPromote, Description: "Grant and revoke roles, and manage other staff accounts."},
+	{Name: PermissionUsersDelete, Description: "Delete user accounts."},
+	{Name: PermissionUsersInvite, Description: "Issue and revoke invite codes, with any number of uses."},
+	{Name: PermissionUsersApprove, Description: "Approve or reject new accounts awaiting approval."},
+	{Name: PermissionAuditView, Description: "View the audit log."},
+}
+
+// Built-in roles.
+const (
+	RoleSuperuser = "superuser"
+	RoleModerator = "moderator"
+)
+
+// Roles created at startup if they don't exist. The superuser always has every permission; the
+// others get theirs when created.
+var defaultRoles = []struct {
+	Name        string
+	Description string
+	Permissions []string
+}{
+	{RoleSuperuser, "Full access to everything.", nil},
+	{RoleModerator, "Keeps the peace: may ban and suspend members, but not manage staff or delete accounts.", []string{
+		PermissionUsersView,
+		PermissionUsersBan,
+		PermissionUsersSuspend,
+		PermissionUsersApprove,
+		PermissionAuditView,
+	}},
+}
+
+// SeedRoles syncs the permissions table, creates the built-in roles and gives the superuser role
+// to admins from before roles existed. It is run by AutoMigrate.
+func SeedRoles() error {
+	var all = []Permission{}
+	for _, p := range Permissions {
+		var perm = Permission{}
+		if err := DB.Where(Permission{Name: p.Name}).Assign(Permission{Description: p.Description}).FirstOrCreate(&perm).Error; err != nil {
+			return err
+		}
+		all = append(all, perm)
+	}
+
+	for _, def := range defaultRoles {
+		var role = Role{}
+		result := DB.Where(Role{Name: def.Name}).Attrs(Role{Description: def.Description}).FirstOrCreate(&role)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if def.Name == RoleSuperuser {
+			if err := DB.Model(&role).Association("Permissions").Replace(all); err != nil {
+				return err
+			}
+		} else if result.RowsAffected > 0 {
+			var perms = []Permission{}
+			if err := DB.Where("name IN ?", def.Permissions).Find(&perms).Error; err != nil {
+				return err
+			}
+			if err := DB.Model(&role).Association("Permissions").Replace(perms); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Admins from before roles existed become superusers.
+	superuser, err := GetRole(RoleSuperuser)
+	if err != nil {
+		return err
+	}
+
+	var admins = []*User{}
+	if err := DB.Where("is_admin = ? AND id NOT IN (?)", true, DB.Model(&UserRole{}).Select("user_id")).Find(&admins).Error; err != nil {
+		return err
+	}
+	for _, admin := range admins {
+		log.Warn("SeedRoles: granting the %s role to admin %s", RoleSuperuser, admin.Username)
+		if err := admin.GrantRole(superuser); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetRole by name.
+func GetRole(name string) (*Role, error) {
+	role := &Role{}
+	result := DB.Preload("Permissions").Where("name = ?", name).First(role)
+	return role, result.Error
+}
+
+// GetRoles returns all the roles, by name.
+func GetRoles() ([]*Role, error) {
+	var roles = []*Role{}
+	result := DB.Preload("Permissions").Order("name").Find(&roles)
+	return roles, result.Error
+}
+
+// Roles granted to the user, by name.
+func (u *User) Roles() ([]*Role, error) {
+	var roles = []*Role{}
+	result := DB.Preload("Permissions").
+		Where("id IN (?)", DB.Model(&UserRole{}).Select("role_id").Where("user_id = ?", u.ID)).
+		Order("name").
+		Find(&roles)
+	return roles, result.Error
+}
+
+// GetRoleUsers returns the users who have been granted a role, by username.
+func GetRoleUsers(role *Role) ([]*User, error) {
+	var users = []*User{}
+	result := DB.Where("id IN (?)", DB.Model(&UserRole{}).Select("user_id").Where("role_id = ?", role.ID)).
+		Order("username").
+		Find(&users)
+	return users, result.Error
+}
+
+// GrantRole gives the user a role, if they don't already have it.
+func (u *User) GrantRole(role *Role) error {
+	if err := DB.Where(UserRole{UserID: u.ID, RoleID: role.ID}).FirstOrCreate(&UserRole{}).Error; err != nil {
+		return err
+	}
+	return u.syncRoles()
+}
+
+// RevokeRole takes a role away from the user.
+func (u *User) RevokeRole(role *Role) error {
+	if err := DB.Where("user_id = ? AND role_id = ?", u.ID, role.ID).Delete(&UserRole{}).Error; err != nil {
+		return err
+	}
+	return u.syncRoles()
+}
+
+// SetRoles replaces all of the user's roles.
+func (u *User) SetRoles(roles []*Role) error {
+	if err := DeleteUserRoles(u.ID); err != nil {
+		return err
+	}
+	for _, role := range roles {
+		if err := DB.Create(&UserRole{UserID: u.ID, RoleID: role.ID}).Error; err != nil {
+			return err
+		}
+	}
+	return u.syncRoles()
+}
+
+// syncRoles updates IsAdmin after the user's roles change, and forgets their cached permissions.
+func (u *User) syncRoles() error {
+	var count int64
+	if err := DB.Model(&UserRole{}).Where("user_id = ?", u.ID).Count(&count).Error; err != nil {
+		return err
+	}
+
+	u.permissions = nil
+	if u.IsAdmin != (count > 0) {
+		u.IsAdmin = count > 0
+		return u.Save()
+	}
+	return nil
+}
+
+// HasPermission checks if any of the user's roles allows something, e.g. "users.ban". The
+// permissions are looked up once per User object.
+func (u *User) HasPermission(name string) bool {
+	if u == nil || u.ID == 0 {
+		return false
+	}
+
+	if u.permissions == nil {
+		var names = []string{}
+		err := DB.Model(&Permission{}).
+			Joins("JOIN role_permissions ON role_permissions.permission_id = permissions.id").
+			Joins("JOIN user_roles ON user_roles.role_id = role_permissions.role_id").
+			Where("user_roles.user_id = ?", u.ID).
+			Distinct().
+			Pluck("permissions.name", &names).Error
+		if err != nil {
+			log.Error("HasPermission(%s): %s", u.Username, err)
+			return false
+		}
+
+		u.permissions = map[string]bool{}
+		for _, name := range names {
+			u.permissions[name] = true
+		}
+	}
+
+	return u.permissions[name]
+}
+
+// CanManage checks if the user may act on another's account (ban, suspend, delete...) with the
+// given permission. Accounts with roles may only be managed by those who can promote, and who
+// have every permission the other does.
+func (u *User) CanManage(other *User, permission string) bool {
+	if !u.HasPermission(permission) {
+		return false
+	} else if !other.IsAdmin {
+		return true
+	} else if !u.HasPermission(PermissionUsersPromote) {
+		return false
+	}
+
+	if u.IsSuperuser() {
+		return true
+	} else if other.IsSuperuser() {
+		return false
+	}
+	for _, p := range Permissions {
+		if other.HasPermission(p.Name) && !u.HasPermission(p.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// CanGrant checks if the user may grant or revoke a role: they need every permission it has, and
+// only a superuser may hand out the superuser role.
+func (u *User) CanGrant(role *Role) bool {
+	if u.IsSuperuser() {
+		return true
+	} else if role.Name == RoleSuperuser {
+		return false
+	}
+	for _, p := range role.Permissions {
+		if !u.HasPermission(p.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSuperuser checks if the user has the superuser role.
+func (u *User) IsSuperuser() bool {
+	if u == nil || u.ID == 0 || !u.IsAdmin {
+		return false
+	}
+
+	var count int64
+	err := DB.Model(&UserRole{}).
+		Joins("JOIN roles ON roles.id = user_roles.role_id").
+		Where("user_roles.user_id = ? AND roles.name = ?", u.ID, RoleSuperuser).
+		Count(&count).Error
+	if err != nil {
+		log.Error("IsSuperuser(%s): %s", u.Username, err)
+		return false
+	}
+	return count > 0
+}
+
+// RoleNames lists the names of roles, e.g. for the audit log.
+func RoleNames(roles []*Role) string {
+	var names = []string{}
+	for _, role := range roles {
+		names = append(names, role.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// PermissionNames lists the names of the role's permissions.
+func (r *Role) PermissionNames() string {
+	var names = []string{}
+	for _, p := range r.Permissions {
+		names = append(names, p.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// DeleteUserRoles removes all roles from a user ID.
+func DeleteUserRoles(userID uint64) error {
+	return DB.Where("user_id = ?", userID).Delete(&UserRole{}).Error
+}
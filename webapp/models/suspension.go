@@ -0,0 +1,79 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Suspension table: why and until when a suspended user is locked out. There is at most one per
+// user, removed when they are reinstated; past suspensions are in the audit log.
+type Suspension struct {
+	ID        uint64 `gorm:"primaryKey"`
+	UserID    uint64 `gorm:"uniqueIndex"`
+	AdminID   uint64 // the admin who suspended them
+	Reason    string
+	ExpiresAt time.Time `gorm:"index"` // zero value = until an admin lifts it
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// GetSuspension looks up the suspension on a user ID.
+func GetSuspension(userID uint64) (*Suspension, error) {
+	s := &Suspension{}
+	result := DB.Where("user_id = ?", userID).First(s)
+	return s, result.Error
+}
+
+// Suspend a user account, replacing any suspension already on it. Give a zero expiresAt to
+// suspend them until an admin lifts it.
+//
+// Only active accounts may be suspended, as reinstating one makes it active again: a banned or
+// pending account must not come out of a suspension active.
+func (u *User) Suspend(admin *User, reason string, expiresAt time.Time) (*Suspension, error) {
+	if admin == nil || admin.ID == 0 {
+		return nil, errors.New("an admin is required to suspend a user")
+	} else if u.Status != UserStatusActive && u.Status != UserStatusSuspended {
+		return nil, fmt.Errorf("only active accounts can be suspended, and %s is %s", u.Username, u.Status)
+	}
+
+	s, err := GetSuspension(u.ID)
+	if err != nil {
+		s = &Suspension{
+			UserID: u.ID,
+		}
+	}
+	s.AdminID = admin.ID
+	s.Reason = reason
+	s.ExpiresAt = expiresAt
+	if err := s.Save(); err != nil {
+		return nil, err
+	}
+
+	u.Status = UserStatusSuspended
+	return s, u.Save()
+}
+
+// Reinstate a suspended user account.
+func (u *User) Reinstate() error {
+	if err := DeleteSuspension(u.ID); err != nil {
+		return err
+	}
+	u.Status = UserStatusActive
+	return u.Save()
+}
+
+// Expired returns whether the suspension has run its course.
+func (s *Suspension) Expired() bool {
+	return !s.ExpiresAt.IsZero() && time.Now().After(s.ExpiresAt)
+}
+
+// Save the suspension.
+func (s *Suspension) Save() error {
+	return DB.Save(s).Error
+}
+
+// DeleteSuspension removes the suspension on a user ID, if any.
+func DeleteSuspension(userID uint64) error {
+	return DB.Where("user_id = ?", userID).Delete(&Suspension{}).Error
+}
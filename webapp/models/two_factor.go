@@ -0,0 +1,174 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/totp"
+)
+
+// TwoFactor table holds a user's TOTP secret and hashed recovery codes.
+type TwoFactor struct {
+	ID            uint64 `gorm:"primaryKey"`
+	UserID        uint64 `gorm:"uniqueIndex"`
+	Secret        string // base32 TOTP shared secret
+	Enabled       bool   // false until the user has confirmed their first code
+	RecoveryCodes string // newline separated SHA-256 hashes of unused recovery codes
+	LastCounter   int64  // time step of the last accepted code, so it can't be used again
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// GetTwoFactor looks up the two-factor settings for a user ID.
+func GetTwoFactor(userID uint64) (*TwoFactor, error) {
+	tf := &TwoFactor{}
+	result := DB.Where("user_id = ?", userID).First(tf)
+	return tf, result.Error
+}
+
+// NewTwoFactor begins two-factor enrollment for a user with a fresh secret. It replaces
+// any unconfirmed secret, but will not touch one that is already enabled.
+func NewTwoFactor(user *User) (*TwoFactor, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	tf, err := GetTwoFactor(user.ID)
+	if err == nil {
+		if tf.Enabled {
+			return nil, errors.New("two-factor authentication is already enabled")
+		}
+	} else {
+		tf = &TwoFactor{
+			UserID: user.ID,
+		}
+	}
+
+	tf.Secret = secret
+	tf.RecoveryCodes = ""
+	return tf, tf.Save()
+}
+
+// HasTwoFactor returns whether the user has two-factor authentication turned on.
+func (u *User) HasTwoFactor() bool {
+	if tf, err := GetTwoFactor(u.ID); err == nil {
+		return tf.Enabled
+	}
+	return false
+}
+
+// URI returns the otpauth:// URI for the user's authenticator app.
+func (tf *TwoFactor) URI(user *User) string {
+	return totp.URI(user.Username, tf.Secret)
+}
+
+// Validate a TOTP code against the secret. Each code is only accepted once: codes for the time
+// step of the last accepted one, or earlier, are refused.
+func (tf *TwoFactor) Validate(code string) bool {
+	counter, ok := totp.ValidateCounter(code, tf.Secret, time.Now())
+	if !ok || counter <= tf.LastCounter {
+		return false
+	}
+
+	// Claim the time step atomically, in case the same code is submitted twice at once.
+	result := DB.Model(&TwoFactor{}).
+		Where("id = ? AND last_counter < ?", tf.ID, counter).
+		Update("last_counter", counter)
+	if result.Error != nil || result.RowsAffected != 1 {
+		return false
+	}
+
+	tf.LastCounter = counter
+	return true
+}
+
+// GenerateRecoveryCodes creates a new set of single-use recovery codes, replacing any
+// old ones. The plaintext codes are returned to show to the user once; only their hashes
+// are kept in the database.
+func (tf *TwoFactor) GenerateRecoveryCodes() ([]string, error) {
+	var (
+		codes  = []string{}
+		hashes = []string{}
+	)
+	for i := 0; i < config.TwoFactorRecoveryCodes; i++ {
+		var buf = make([]byte, 5)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+
+		code := hex.EncodeToString(buf)
+		code = code[:5] + "-" + code[5:]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+
+	tf.RecoveryCodes = strings.Join(hashes, "\n")
+	return codes, nil
+}
+
+// UseRecoveryCode checks a recovery code and, if valid, burns it so it can't be used again.
+func (tf *TwoFactor) UseRecoveryCode(code string) bool {
+	var (
+		hash   = hashRecoveryCode(code)
+		remain = []string{}
+		found  bool
+	)
+	for _, stored := range strings.Split(tf.RecoveryCodes, "\n") {
+		if stored == "" {
+			continue
+		}
+		if !found && subtle.ConstantTimeCompare([]byte(stored), []byte(hash)) == 1 {
+			found = true
+			continue
+		}
+		remain = append(remain, stored)
+	}
+
+	if !found {
+		return false
+	}
+
+	tf.RecoveryCodes = strings.Join(remain, "\n")
+	if err := tf.Save(); err != nil {
+		return false
+	}
+	return true
+}
+
+// RecoveryCodesRemaining counts the unused recovery codes.
+func (tf *TwoFactor) RecoveryCodesRemaining() int {
+	var count int
+	for _, stored := range strings.Split(tf.RecoveryCodes, "\n") {
+		if stored != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// Save the two-factor settings.
+func (tf *TwoFactor) Save() error {
+	return DB.Save(tf).Error
+}
+
+// Delete the two-factor settings, turning 2FA off for the user.
+func (tf *TwoFactor) Delete() error {
+	return DB.Delete(tf).Error
+}
+
+// hashRecoveryCode normalizes and hashes a recovery code for storage.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+	sum := sha256.Sum256([]byte(code))
+	return fmt.Sprintf("%x", sum)
+}
@@ -8,7 +8,7 @@ import (
 	"time"
 
 	"github.com/aichaos/silhouette/webapp/config"
-	"golang.org/x/crypto/bcrypt"
+	passwd "github.com/aichaos/silhouette/webapp/password"
 	"gorm.io/gorm"
 )
 
@@ -18,12 +18,15 @@ type User struct {
 	Username       string `gorm:"uniqueIndex"`
 	Email          string `gorm:"uniqueIndex"`
 	HashedPassword string
-	IsAdmin        bool       `gorm:"index"`
-	Status         UserStatus `gorm:"index"` // active, disabled
+	IsAdmin        bool       `gorm:"index"` // staff: has been granted any role (see HasPermission)
+	Status         UserStatus `gorm:"index"` // active, banned, etc. (see UserStatus options)
+	InviteID       uint64     `gorm:"index"` // the invite they signed up with, if any
 
 	CreatedAt   time.Time `gorm:"index"`
 	UpdatedAt   time.Time `gorm:"index"`
 	LastLoginAt time.Time `gorm:"index"`
+
+	permissions map[string]bool // cache for HasPermission
 }
 
 // Preload related tables for the user (classmethod).
@@ -37,13 +40,23 @@ func (u *User) Preload() *gorm.DB {
 type UserStatus string
 
 const (
-	UserStatusActive   = "active"
-	UserStatusDisabled = "disabled"
-	UserStatusBanned   = "banned"
+	UserStatusActive          = "active"
+	UserStatusPending         = "pending"          // awaiting approval by an admin
+	UserStatusDeactivated     = "deactivated"      // by the user, who may reactivate it by logging in
+	UserStatusPendingDeletion = "pending_deletion" // by the user, see DeletionRequest
+	UserStatusDisabled        = "disabled"         // by an admin
+	UserStatusSuspended       = "suspended"        // for a while, see Suspension
+	UserStatusBanned          = "banned"
 )
 
 // CreateUser. It is assumed username and email are correctly formatted.
 func CreateUser(username, email, password string) (*User, error) {
+	return CreateUserWithStatus(username, email, password, UserStatusActive)
+}
+
+// CreateUserWithStatus creates a user whose account starts out with another status, such as
+// pending approval.
+func CreateUserWithStatus(username, email, password string, status UserStatus) (*User, error) {
 	// Verify username and email are unique.
 	if _, err := FindUser(username); err == nil {
 		return nil, errors.New("That username already exists. Please try a different username.")
@@ -54,7 +67,7 @@ func CreateUser(username, email, password string) (*User, error) {
 	u := &User{
 		Username: username,
 		Email:    email,
-		Status:   UserStatusActive,
+		Status:   status,
 	}
 
 	if err := u.HashPassword(password); err != nil {
@@ -90,6 +103,13 @@ func GetUsers(currentUser *User, userIDs []uint64) ([]*User, error) {
 	return users, nil
 }
 
+// CountPendingUsers counts the accounts awaiting approval.
+func CountPendingUsers() (int64, error) {
+	var count int64
+	result := DB.Model(&User{}).Where("status = ?", UserStatusPending).Count(&count)
+	return count, result.Error
+}
+
 // FindUser by username or email.
 func FindUser(username string) (*User, error) {
 	if username == "" {
@@ -105,9 +125,37 @@ func FindUser(username string) (*User, error) {
 	return u, result.Error
 }
 
+// FindProfile finds the member whose profile is at a username, as seen by the viewer (nil when
+// logged out). Accounts the viewer may not see are not found.
+func FindProfile(username string, viewer *User) (*User, error) {
+	// Only look up real usernames: FindUser would also take an email address.
+	username = strings.ToLower(username)
+	if !config.UsernameRegexp.MatchString(username) {
+		return nil, errors.New("not a valid username")
+	}
+
+	user, err := FindUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if !user.VisibleTo(viewer) {
+		return nil, errors.New("user not found")
+	}
+	return user, nil
+}
+
 // UserSearch config.
 type UserSearch struct {
 	EmailOrUsername string
+
+	// Admin filters.
+	Status          UserStatus // empty for any status
+	IsAdmin         *bool      // nil for admins and non-admins alike
+	EmailDomain     string     // e.g. "example.com"
+	CreatedAfter    time.Time  // zero values for no bound
+	CreatedBefore   time.Time
+	LastLoginAfter  time.Time
+	LastLoginBefore time.Time
 }
 
 // SearchUsers from the perspective of a given user.
@@ -129,6 +177,37 @@ func SearchUsers(user *User, search *UserSearch, pager *Pagination) ([]*User, er
 		placeholders = append(placeholders, ilike, ilike)
 	}
 
+	if search.Status != "" {
+		wheres = append(wheres, "status = ?")
+		placeholders = append(placeholders, search.Status)
+	}
+
+	if search.IsAdmin != nil {
+		wheres = append(wheres, "is_admin = ?")
+		placeholders = append(placeholders, *search.IsAdmin)
+	}
+
+	if search.EmailDomain != "" {
+		domain := strings.TrimPrefix(strings.TrimSpace(strings.ToLower(search.EmailDomain)), "@")
+		wheres = append(wheres, "email LIKE ?")
+		placeholders = append(placeholders, "%@"+domain)
+	}
+
+	for _, bound := range []struct {
+		Where string
+		Time  time.Time
+	}{
+		{"created_at >= ?", search.CreatedAfter},
+		{"created_at < ?", search.CreatedBefore},
+		{"last_login_at >= ?", search.LastLoginAfter},
+		{"last_login_at < ?", search.LastLoginBefore},
+	} {
+		if !bound.Time.IsZero() {
+			wheres = append(wheres, bound.Where)
+			placeholders = append(placeholders, bound.Time)
+		}
+	}
+
 	query = (&User{}).Preload().Where(
 		strings.Join(wheres, " AND "),
 		placeholders...,
@@ -189,19 +268,74 @@ func (um UserMap) Get(id uint64) *User {
 	return nil
 }
 
-// HashPassword sets the user's hashed (bcrypt) password.
+// HashPassword sets the user's hashed password, using the current hashing scheme.
 func (u *User) HashPassword(password string) error {
-	passwd, err := bcrypt.GenerateFromPassword([]byte(password), config.BcryptCost)
+	hash, err := passwd.Hash(password)
 	if err != nil {
 		return err
 	}
-	u.HashedPassword = string(passwd)
+	u.HashedPassword = hash
 	return nil
 }
 
 // CheckPassword verifies the password is correct. Returns nil on success.
 func (u *User) CheckPassword(password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(u.HashedPassword), []byte(password))
+	return passwd.Verify(u.HashedPassword, password)
+}
+
+// RehashPassword upgrades the stored hash if it's weaker than the current hashing settings.
+// Call it with the plaintext password right after CheckPassword succeeds.
+func (u *User) RehashPassword(password string) error {
+	if !passwd.NeedsRehash(u.HashedPassword) {
+		return nil
+	}
+
+	if err := u.HashPassword(password); err != nil {
+		return err
+	}
+	return DB.Model(u).Update("hashed_password", u.HashedPassword).Error
+}
+
+// CountPasswordSchemes counts user accounts by the scheme and parameters of their password
+// hash, e.g. "bcrypt cost=14": 120.
+func CountPasswordSchemes() (map[string]int64, error) {
+	var result = map[string]int64{}
+
+	rows, err := DB.Model(&User{}).Select("hashed_password").Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		result[passwd.Describe(hash)]++
+	}
+
+	return result, rows.Err()
+}
+
+// VisibleTo returns whether the user's profile may be shown to the viewer (nil for a logged out
+// visitor). Accounts that aren't active (pending, deactivated, pending deletion, suspended, disabled or
+// banned) are only shown to admins.
+func (u *User) VisibleTo(viewer *User) bool {
+	if viewer.HasPermission(PermissionUsersView) {
+		return true
+	}
+	return u.Status == UserStatusActive
+}
+
+// CanLogIn says whether the account's status lets its owner log in. Pending accounts may, to see
+// that they wait for approval, and deactivated ones to reactivate.
+func (u *User) CanLogIn() bool {
+	switch u.Status {
+	case UserStatusActive, UserStatusPending, UserStatusDeactivated:
+		return true
+	}
+	return false
 }
 
 // Save user.
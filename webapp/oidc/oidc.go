@@ -0,0 +1,432 @@
+// Package oidc implements a minimal OpenID Connect client (authorization code flow with PKCE)
+// for social login with any standards compliant identity provider.
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/router"
+)
+
+// Client for one OpenID Connect provider.
+type Client struct {
+	Name         string // short name used in URLs, e.g. "google"
+	Label        string // display name for buttons, e.g. "Google"
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	RedirectURL  string
+	HTTPClient   *http.Client
+
+	// privates
+	mu        sync.Mutex
+	discovery *Discovery
+	keys      map[string]*rsa.PublicKey
+}
+
+// Discovery document from the provider's /.well-known/openid-configuration.
+type Discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Claims from a verified ID token.
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          Audience `json:"aud"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+	Name              string   `json:"name"`
+}
+
+// Audience claim may be a single string or a list of strings.
+type Audience []string
+
+// UnmarshalJSON accepts either form of the audience claim.
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Contains checks whether the audience includes the client ID.
+func (a Audience) Contains(clientID string) bool {
+	for _, v := range a {
+		if v == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// Registry of configured providers, built from settings.toml on first use.
+var (
+	registry   map[string]*Client
+	registryMu sync.Mutex
+)
+
+// New creates a Client from provider settings.
+func New(p config.OIDCProvider, redirectURL string) *Client {
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	label := p.Label
+	if label == "" {
+		label = p.Name
+	}
+
+	return &Client{
+		Name:         p.Name,
+		Label:        label,
+		Issuer:       strings.TrimSuffix(p.Issuer, "/"),
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		Scopes:       scopes,
+		RedirectURL:  redirectURL,
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Providers returns the Clients for all providers configured in settings.toml.
+func Providers() []*Client {
+	var result = []*Client{}
+	for _, p := range config.Current.OIDC {
+		if c, err := Get(p.Name); err == nil {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// Get a configured provider by name.
+func Get(name string) (*Client, error) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	if registry == nil {
+		registry = map[string]*Client{}
+		for _, p := range config.Current.OIDC {
+			if p.Name == "" || p.Issuer == "" || p.ClientID == "" {
+				continue
+			}
+			registry[p.Name] = New(p, router.MustAbsoluteURLFor("oidc.callback"))
+		}
+	}
+
+	if c, ok := registry[name]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("unknown login provider: %s", name)
+}
+
+// Discover fetches (and caches) the provider's discovery document.
+func (c *Client) Discover() (*Discovery, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.discovery != nil {
+		return c.discovery, nil
+	}
+
+	var doc Discovery
+	if err := c.getJSON(c.Issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("OIDC discovery: %s", err)
+	}
+
+	if strings.TrimSuffix(doc.Issuer, "/") != c.Issuer {
+		return nil, fmt.Errorf("OIDC discovery: issuer mismatch (%s != %s)", doc.Issuer, c.Issuer)
+	}
+
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery: document is missing required endpoints")
+	}
+
+	c.discovery = &doc
+	return c.discovery, nil
+}
+
+// AuthCodeURL returns the URL to send the user to for login at the provider.
+func (c *Client) AuthCodeURL(state, nonce, verifier string) (string, error) {
+	doc, err := c.Discover()
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", c.ClientID)
+	params.Set("redirect_uri", c.RedirectURL)
+	params.Set("scope", strings.Join(c.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", CodeChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return doc.AuthorizationEndpoint + sep + params.Encode(), nil
+}
+
+// Exchange an authorization code for the user's verified ID token claims.
+func (c *Client) Exchange(code, verifier, nonce string) (*Claims, error) {
+	doc, err := c.Discover()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest(http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("token response: %s", err)
+	}
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("token response: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("token response: %d %s %s", resp.StatusCode, token.Error, token.ErrorDescription)
+	}
+
+	if token.IDToken == "" {
+		return nil, errors.New("token response: no id_token was returned")
+	}
+
+	return c.Verify(token.IDToken, nonce)
+}
+
+// Verify an ID token's signature and standard claims and return its claims.
+func (c *Client) Verify(idToken, nonce string) (*Claims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("id_token: malformed JWT")
+	}
+
+	// Parse the header.
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("id_token header: %s", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("id_token: unsupported signing algorithm %s", header.Alg)
+	}
+
+	// Verify the signature.
+	key, err := c.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("id_token signature: %s", err)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, errors.New("id_token: invalid signature")
+	}
+
+	// Check the claims.
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("id_token claims: %s", err)
+	}
+
+	var now = time.Now().Unix()
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != c.Issuer:
+		return nil, fmt.Errorf("id_token: unexpected issuer %s", claims.Issuer)
+	case !claims.Audience.Contains(c.ClientID):
+		return nil, errors.New("id_token: not issued for this client")
+	case claims.Expiry < now-int64(config.OIDCClockSkew.Seconds()):
+		return nil, errors.New("id_token: expired")
+	case claims.Nonce != nonce:
+		return nil, errors.New("id_token: nonce mismatch")
+	case claims.Subject == "":
+		return nil, errors.New("id_token: no subject")
+	}
+
+	return &claims, nil
+}
+
+// key finds the provider's signing key by key ID, refreshing the key set once if not found
+// (the provider may have rotated its keys).
+func (c *Client) key(kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	key, ok := c.keys[kid]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	if err := c.fetchKeys(); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A single key with no kid can sign tokens with no kid.
+	if key, ok := c.keys[kid]; ok {
+		return key, nil
+	} else if kid == "" && len(c.keys) == 1 {
+		for _, key := range c.keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("id_token: signing key %q not found", kid)
+}
+
+// fetchKeys downloads the provider's JSON Web Key Set.
+func (c *Client) fetchKeys() error {
+	doc, err := c.Discover()
+	if err != nil {
+		return err
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := c.getJSON(doc.JWKSURI, &jwks); err != nil {
+		return fmt.Errorf("OIDC keys: %s", err)
+	}
+
+	var keys = map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+	return nil
+}
+
+// getJSON fetches a URL and decodes its JSON response.
+func (c *Client) getJSON(url string, v interface{}) error {
+	resp, err := c.HTTPClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// decodeSegment decodes a base64url JWT segment as JSON.
+func decodeSegment(seg string, v interface{}) error {
+	bin, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(bin, v)
+}
+
+// RandomString returns a random URL-safe string for state, nonce and PKCE values.
+func RandomString() (string, error) {
+	var buf = make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// CodeChallenge computes the PKCE S256 code challenge for a verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,270 @@
+package oidc_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/oidc"
+)
+
+// mockIdP is an in-process OpenID Connect identity provider for tests.
+type mockIdP struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	// Claims the next token response will carry, and the PKCE challenge it expects.
+	claims    map[string]interface{}
+	challenge string
+	signWith  *rsa.PrivateKey // override to sign with a different key
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %s", err)
+	}
+
+	idp := &mockIdP{
+		t:   t,
+		key: key,
+		kid: "test-key",
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": idp.kid,
+					"use": "sig",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				},
+			},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+
+		// Check client authentication and PKCE.
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client-id" || secret != "client-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		if r.FormValue("code") != "good-code" || oidc.CodeChallenge(r.FormValue("code_verifier")) != idp.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     idp.sign(idp.claims),
+		})
+	})
+
+	idp.server = httptest.NewServer(mux)
+	return idp
+}
+
+// sign a JWT with the IdP's key.
+func (idp *mockIdP) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": idp.kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	key := idp.key
+	if idp.signWith != nil {
+		key = idp.signWith
+	}
+
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		idp.t.Fatalf("SignPKCS1v15: %s", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func (idp *mockIdP) client() *oidc.Client {
+	return oidc.New(config.OIDCProvider{
+		Name:         "mock",
+		Issuer:       idp.server.URL,
+		ClientID:     "client-id",
+		ClientSecret: "client-secret",
+	}, "http://localhost:8080/auth/oidc/callback")
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.server.Close()
+
+	authURL, err := idp.client().AuthCodeURL("the-state", "the-nonce", "the-verifier")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %s", err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("AuthCodeURL returned an invalid URL: %s", err)
+	}
+
+	if !strings.HasPrefix(authURL, idp.server.URL+"/authorize?") {
+		t.Errorf("AuthCodeURL should point to the authorization endpoint, got %s", authURL)
+	}
+
+	var expect = map[string]string{
+		"response_type":         "code",
+		"client_id":             "client-id",
+		"redirect_uri":          "http://localhost:8080/auth/oidc/callback",
+		"scope":                 "openid email profile",
+		"state":                 "the-state",
+		"nonce":                 "the-nonce",
+		"code_challenge":        oidc.CodeChallenge("the-verifier"),
+		"code_challenge_method": "S256",
+	}
+	for k, v := range expect {
+		if actual := u.Query().Get(k); actual != v {
+			t.Errorf("AuthCodeURL param %s: expected %q but got %q", k, v, actual)
+		}
+	}
+}
+
+func TestExchange(t *testing.T) {
+	idp := newMockIdP(t)
+	defer idp.server.Close()
+
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":                idp.server.URL,
+			"sub":                "user-1234",
+			"aud":                "client-id",
+			"exp":                time.Now().Add(time.Hour).Unix(),
+			"iat":                time.Now().Unix(),
+			"nonce":              "the-nonce",
+			"email":              "alice@example.com",
+			"email_verified":     true,
+			"preferred_username": "alice",
+		}
+	}
+
+	var tests = []struct {
+		Name     string
+		Code     string
+		Modify   func(map[string]interface{})
+		SignWith *rsa.PrivateKey
+		Error    string // expected substring of the error; blank for success
+	}{
+		{
+			Name: "valid login",
+			Code: "good-code",
+		},
+		{
+			Name: "audience as a list",
+			Code: "good-code",
+			Modify: func(c map[string]interface{}) {
+				c["aud"] = []string{"someone-else", "client-id"}
+			},
+		},
+		{
+			Name:  "bad authorization code",
+			Code:  "bad-code",
+			Error: "invalid_grant",
+		},
+		{
+			Name:     "signed by the wrong key",
+			Code:     "good-code",
+			SignWith: otherKey,
+			Error:    "invalid signature",
+		},
+		{
+			Name: "wrong nonce (replay)",
+			Code: "good-code",
+			Modify: func(c map[string]interface{}) {
+				c["nonce"] = "some-other-nonce"
+			},
+			Error: "nonce mismatch",
+		},
+		{
+			Name: "wrong audience",
+			Code: "good-code",
+			Modify: func(c map[string]interface{}) {
+				c["aud"] = "another-client"
+			},
+			Error: "not issued for this client",
+		},
+		{
+			Name: "wrong issuer",
+			Code: "good-code",
+			Modify: func(c map[string]interface{}) {
+				c["iss"] = "https://evil.example.com"
+			},
+			Error: "unexpected issuer",
+		},
+		{
+			Name: "expired",
+			Code: "good-code",
+			Modify: func(c map[string]interface{}) {
+				c["exp"] = time.Now().Add(-time.Hour).Unix()
+			},
+			Error: "expired",
+		},
+	}
+
+	for _, test := range tests {
+		client := idp.client()
+		verifier, _ := oidc.RandomString()
+
+		idp.claims = validClaims()
+		if test.Modify != nil {
+			test.Modify(idp.claims)
+		}
+		idp.challenge = oidc.CodeChallenge(verifier)
+		idp.signWith = test.SignWith
+
+		claims, err := client.Exchange(test.Code, verifier, "the-nonce")
+		if test.Error != "" {
+			if err == nil || !strings.Contains(err.Error(), test.Error) {
+				t.Errorf("%s: expected error containing %q but got: %v", test.Name, test.Error, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", test.Name, err)
+			continue
+		}
+
+		if claims.Subject != "user-1234" || claims.Email != "alice@example.com" || !claims.EmailVerified || claims.PreferredUsername != "alice" {
+			t.Errorf("%s: unexpected claims: %s", test.Name, fmt.Sprintf("%+v", claims))
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+)
+
+// Ranges smaller than this are scanned line by line instead of bisected.
+const linearScanSize = 4096
+
+// IsBreached checks whether a password appears in a local breached password file.
+//
+// The file holds uppercase or lowercase hex SHA-1 hashes, one per line and sorted, optionally
+// followed by ":count" as in the Have I Been Pwned downloads. It is binary searched on disk, so
+// even the full multi-gigabyte list is cheap to check and never loaded into memory.
+func IsBreached(filename, password string) (bool, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return false, err
+	}
+	defer fh.Close()
+
+	stat, err := fh.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	var sum = sha1.Sum([]byte(password))
+	return searchSorted(fh, stat.Size(), strings.ToUpper(hex.EncodeToString(sum[:])))
+}
+
+// searchSorted binary searches a sorted file of hash lines for the hash.
+//
+// Invariant: lo is always the start of a line and hi is the start of a line (or the end of
+// file), and a matching line can only start within [lo, hi).
+func searchSorted(r io.ReaderAt, size int64, hash string) (bool, error) {
+	var lo, hi int64 = 0, size
+	for hi-lo > linearScanSize {
+		var mid = lo + (hi-lo)/2
+
+		// Find the start of the first line after mid.
+		start, err := nextLine(r, mid, hi)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			break
+		}
+
+		line, err := readLine(r, start, size)
+		if err != nil {
+			return false, err
+		}
+
+		switch key := lineKey(line); {
+		case key == hash:
+			return true, nil
+		case key < hash:
+			lo = start + int64(len(line)) + 1
+		default:
+			hi = start
+		}
+	}
+
+	// Scan what's left.
+	var scanner = bufio.NewScanner(io.NewSectionReader(r, lo, hi-lo))
+	for scanner.Scan() {
+		if lineKey(scanner.Bytes()) == hash {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// nextLine returns the offset just after the first newline at or after offset, up to limit.
+func nextLine(r io.ReaderAt, offset, limit int64) (int64, error) {
+	var buf = make([]byte, 256)
+	for offset < limit {
+		n, err := r.ReadAt(buf, offset)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return offset + int64(i) + 1, nil
+		}
+		offset += int64(n)
+		if err == io.EOF {
+			return limit, nil
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	return limit, nil
+}
+
+// readLine reads the line starting at offset, without its newline.
+func readLine(r io.ReaderAt, offset, size int64) ([]byte, error) {
+	var reader = bufio.NewReader(io.NewSectionReader(r, offset, size-offset))
+	line, err := reader.ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return bytes.TrimSuffix(line, []byte("\n")), nil
+}
+
+// lineKey returns the uppercased hash from a "HASH:count" line.
+func lineKey(line []byte) string {
+	if i := bytes.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.ToUpper(string(bytes.TrimSpace(line)))
+}
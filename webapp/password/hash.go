@@ -0,0 +1,208 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported hashing schemes. Hashes are self-describing: bcrypt's begin with "$2a$" (or $2b$,
+// $2y$) and the cost, and argon2id's use the PHC string format:
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+const (
+	SchemeBcrypt   = "bcrypt"
+	SchemeArgon2id = "argon2id"
+)
+
+// ErrMismatch is returned by Verify when the password is incorrect.
+var ErrMismatch = errors.New("incorrect password")
+
+var b64 = base64.RawStdEncoding
+
+// HashParams are the algorithm and cost settings for new password hashes.
+type HashParams struct {
+	Scheme          string
+	BcryptCost      int
+	Argon2Time      uint32
+	Argon2Memory    uint32 // KiB
+	Argon2Threads   uint8
+	Argon2KeyLength uint32
+	Argon2SaltSize  int
+}
+
+// CurrentHashParams are the settings from config.go.
+var CurrentHashParams = HashParams{
+	Scheme:          config.PasswordHashScheme,
+	BcryptCost:      config.BcryptCost,
+	Argon2Time:      config.Argon2Time,
+	Argon2Memory:    config.Argon2Memory,
+	Argon2Threads:   config.Argon2Threads,
+	Argon2KeyLength: config.Argon2KeyLength,
+	Argon2SaltSize:  config.Argon2SaltLength,
+}
+
+// Hash a password with the current settings.
+func Hash(password string) (string, error) {
+	return CurrentHashParams.Hash(password)
+}
+
+// NeedsRehash says whether a stored hash uses a different scheme or weaker parameters than the
+// current settings, so should be replaced next time we see the plaintext password.
+func NeedsRehash(hash string) bool {
+	return CurrentHashParams.NeedsRehash(hash)
+}
+
+// String describes the settings in the same form as Describe.
+func (p HashParams) String() string {
+	switch p.Scheme {
+	case SchemeBcrypt:
+		return fmt.Sprintf("%s cost=%d", SchemeBcrypt, p.BcryptCost)
+	case SchemeArgon2id:
+		return fmt.Sprintf("%s m=%d,t=%d,p=%d", SchemeArgon2id, p.Argon2Memory, p.Argon2Time, p.Argon2Threads)
+	default:
+		return "unknown"
+	}
+}
+
+// Hash a password with these settings.
+func (p HashParams) Hash(password string) (string, error) {
+	switch p.Scheme {
+	case SchemeBcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+		return string(hash), err
+	case SchemeArgon2id:
+		var salt = make([]byte, p.Argon2SaltSize)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, p.Argon2Time, p.Argon2Memory, p.Argon2Threads, p.Argon2KeyLength)
+		return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+			SchemeArgon2id, argon2.Version,
+			p.Argon2Memory, p.Argon2Time, p.Argon2Threads,
+			b64.EncodeToString(salt), b64.EncodeToString(key),
+		), nil
+	default:
+		return "", fmt.Errorf("unsupported password hash scheme %q", p.Scheme)
+	}
+}
+
+// NeedsRehash says whether the stored hash is of another scheme or weaker than these settings.
+func (p HashParams) NeedsRehash(hash string) bool {
+	switch Scheme(hash) {
+	case SchemeBcrypt:
+		if p.Scheme != SchemeBcrypt {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err != nil || cost < p.BcryptCost
+	case SchemeArgon2id:
+		if p.Scheme != SchemeArgon2id {
+			return true
+		}
+		a, err := parseArgon2id(hash)
+		return err != nil ||
+			a.version < argon2.Version ||
+			a.memory < p.Argon2Memory ||
+			a.time < p.Argon2Time ||
+			a.threads < p.Argon2Threads ||
+			uint32(len(a.key)) < p.Argon2KeyLength
+	default:
+		return true
+	}
+}
+
+// Verify a password against a stored hash of any supported scheme. Returns nil on success.
+func Verify(hash, password string) error {
+	switch Scheme(hash) {
+	case SchemeBcrypt:
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return ErrMismatch
+		}
+		return err
+	case SchemeArgon2id:
+		a, err := parseArgon2id(hash)
+		if err != nil {
+			return err
+		}
+		key := argon2.IDKey([]byte(password), a.salt, a.time, a.memory, a.threads, uint32(len(a.key)))
+		if subtle.ConstantTimeCompare(key, a.key) != 1 {
+			return ErrMismatch
+		}
+		return nil
+	default:
+		return errors.New("unrecognized password hash")
+	}
+}
+
+// Scheme returns the hashing scheme of a stored hash, or "unknown".
+func Scheme(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return SchemeBcrypt
+	case strings.HasPrefix(hash, "$"+SchemeArgon2id+"$"):
+		return SchemeArgon2id
+	default:
+		return "unknown"
+	}
+}
+
+// Describe a stored hash's scheme and parameters (not the hash itself), e.g. "bcrypt cost=14"
+// or "argon2id m=65536,t=3,p=4".
+func Describe(hash string) string {
+	switch Scheme(hash) {
+	case SchemeBcrypt:
+		if cost, err := bcrypt.Cost([]byte(hash)); err == nil {
+			return fmt.Sprintf("%s cost=%d", SchemeBcrypt, cost)
+		}
+	case SchemeArgon2id:
+		if a, err := parseArgon2id(hash); err == nil {
+			return fmt.Sprintf("%s m=%d,t=%d,p=%d", SchemeArgon2id, a.memory, a.time, a.threads)
+		}
+	}
+	return "unknown"
+}
+
+type argon2idHash struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// parseArgon2id parses an argon2id PHC string.
+func parseArgon2id(hash string) (*argon2idHash, error) {
+	var (
+		parts = strings.Split(hash, "$")
+		a     = &argon2idHash{}
+		err   error
+	)
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	if len(parts) != 6 || parts[1] != SchemeArgon2id {
+		return nil, errors.New("malformed argon2id hash")
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &a.version); err != nil {
+		return nil, fmt.Errorf("malformed argon2id hash version: %s", err)
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &a.memory, &a.time, &a.threads); err != nil {
+		return nil, fmt.Errorf("malformed argon2id hash parameters: %s", err)
+	}
+	if a.salt, err = b64.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("malformed argon2id hash salt: %s", err)
+	}
+	if a.key, err = b64.DecodeString(parts[5]); err != nil || len(a.key) == 0 {
+		return nil, errors.New("malformed argon2id hash key")
+	}
+	return a, nil
+}
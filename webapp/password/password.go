@@ -0,0 +1,128 @@
+// Package password enforces the site's password policy on new passwords.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
+)
+
+// FieldError is a policy failure for one form field.
+type FieldError struct {
+	Field   string // form field name, e.g. "password"
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Message
+}
+
+// Errors is the list of policy failures for a password.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	return strings.Join(Messages(e), " ")
+}
+
+// Messages returns the messages from an error returned by Check, for flashing to the user one
+// by one.
+func Messages(err error) []string {
+	if errs, ok := err.(Errors); ok {
+		var messages = []string{}
+		for _, e := range errs {
+			messages = append(messages, e.Message)
+		}
+		return messages
+	}
+	return []string{err.Error()}
+}
+
+// Check a new password against the current policy.
+//
+// The field is the name of the form input the password came from, and the username and email
+// belong to the account it's for. Returns Errors listing every rule it broke, or nil.
+func Check(field, password, username, email string) error {
+	return CheckPolicy(config.Current.PasswordPolicy, field, password, username, email)
+}
+
+// CheckPolicy checks a password against a given policy.
+func CheckPolicy(policy config.PasswordPolicy, field, password, username, email string) error {
+	var errs Errors
+	fail := func(format string, v ...interface{}) {
+		errs = append(errs, FieldError{
+			Field:   field,
+			Message: fmt.Sprintf(format, v...),
+		})
+	}
+
+	if password == "" {
+		fail("A password is required.")
+		return errs
+	}
+
+	if n := len([]rune(password)); n < policy.MinLength {
+		fail("Your password must be at least %d characters long.", policy.MinLength)
+	}
+
+	// Character classes.
+	var lower, upper, digit, symbol bool
+	for _, c := range password {
+		switch {
+		case unicode.IsLower(c):
+			lower = true
+		case unicode.IsUpper(c):
+			upper = true
+		case unicode.IsDigit(c):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	if policy.RequireLower && !lower {
+		fail("Your password must contain a lowercase letter.")
+	}
+	if policy.RequireUpper && !upper {
+		fail("Your password must contain an uppercase letter.")
+	}
+	if policy.RequireDigit && !digit {
+		fail("Your password must contain a number.")
+	}
+	if policy.RequireSymbol && !symbol {
+		fail("Your password must contain a symbol or punctuation mark.")
+	}
+
+	// Don't use your username or email as your password.
+	if policy.RejectUserInfo {
+		var lc = strings.ToLower(password)
+		if username != "" && strings.Contains(lc, strings.ToLower(username)) {
+			fail("Your password must not contain your username.")
+		} else if email != "" {
+			var local = strings.ToLower(strings.SplitN(email, "@", 2)[0])
+			if strings.Contains(lc, strings.ToLower(email)) || (len(local) >= 3 && strings.Contains(lc, local)) {
+				fail("Your password must not contain your email address.")
+			}
+		}
+	}
+
+	if policy.MinStrength > 0 && Strength(password) < policy.MinStrength {
+		fail("Your password is too easy to guess. Try a longer password or a few unrelated words.")
+	}
+
+	// Only bother checking the breach list for an otherwise acceptable password.
+	if len(errs) == 0 && policy.BreachedHashFile != "" {
+		breached, err := IsBreached(policy.BreachedHashFile, password)
+		if err != nil {
+			log.Error("password.Check: couldn't search breached password file: %s", err)
+		} else if breached {
+			fail("That password has appeared in a data breach and can't be used. Please choose a different password.")
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
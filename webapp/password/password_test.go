@@ -0,0 +1,204 @@
+package password_test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/password"
+)
+
+func TestCheckPolicy(t *testing.T) {
+	var policy = config.PasswordPolicy{
+		MinLength:      8,
+		RequireDigit:   true,
+		RejectUserInfo: true,
+		MinStrength:    2,
+	}
+
+	var tests = []struct {
+		Password string
+		Expect   []string // expected substrings of each message; none for a valid password
+	}{
+		{
+			Password: "kdjf82hqwe",
+		},
+		{
+			Password: "",
+			Expect:   []string{"required"},
+		},
+		{
+			Password: "ab1",
+			Expect:   []string{"at least 8 characters", "too easy to guess"},
+		},
+		{
+			Password: "kdjfhqwepz",
+			Expect:   []string{"contain a number"},
+		},
+		{
+			Password: "Alice2024!xyz",
+			Expect:   []string{"username"},
+		},
+		{
+			Password: "wonderland9!Q",
+			Expect:   []string{"email address"},
+		},
+		{
+			Password: "12345678",
+			Expect:   []string{"too easy to guess"},
+		},
+	}
+
+	for _, test := range tests {
+		err := password.CheckPolicy(policy, "new_password", test.Password, "alice", "wonderland@example.com")
+		if len(test.Expect) == 0 {
+			if err != nil {
+				t.Errorf("%q: expected no error but got: %s", test.Password, err)
+			}
+			continue
+		}
+
+		errs, ok := err.(password.Errors)
+		if !ok {
+			t.Errorf("%q: expected password.Errors but got: %v", test.Password, err)
+			continue
+		}
+		if len(errs) != len(test.Expect) {
+			t.Errorf("%q: expected %d errors but got %d: %s", test.Password, len(test.Expect), len(errs), errs)
+			continue
+		}
+		for i, e := range errs {
+			if e.Field != "new_password" {
+				t.Errorf("%q: expected field new_password but got %s", test.Password, e.Field)
+			}
+			if !strings.Contains(e.Message, test.Expect[i]) {
+				t.Errorf("%q: expected error %d to contain %q but got: %s", test.Password, i, test.Expect[i], e.Message)
+			}
+		}
+	}
+}
+
+func TestStrength(t *testing.T) {
+	var tests = []struct {
+		Password string
+		Expect   int
+	}{
+		{"aaaaaaaaaaaa", 0},
+		{"abcdefgh", 0},
+		{"password", 1},
+		{"kdjfhqwe", 2},
+		{"Tr0ub4dor&3", 3},
+		{"correct horse battery staple", 4},
+	}
+
+	for _, test := range tests {
+		if actual := password.Strength(test.Password); actual != test.Expect {
+			t.Errorf("Strength(%q): expected %d but got %d (%.1f bits)", test.Password, test.Expect, actual, password.Entropy(test.Password))
+		}
+	}
+}
+
+func TestIsBreached(t *testing.T) {
+	// Build a sorted hash file big enough to be bisected.
+	var (
+		breached = []string{"password", "123456", "hunter2"}
+		lines    = []string{}
+	)
+	for i := 0; i < 2000; i++ {
+		breached = append(breached, fmt.Sprintf("filler-%d", i))
+	}
+	for i, pw := range breached {
+		sum := sha1.Sum([]byte(pw))
+		lines = append(lines, fmt.Sprintf("%s:%d", strings.ToUpper(hex.EncodeToString(sum[:])), i+1))
+	}
+	sort.Strings(lines)
+
+	var filename = filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(filename, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	for _, pw := range breached {
+		if ok, err := password.IsBreached(filename, pw); err != nil || !ok {
+			t.Errorf("IsBreached(%q): expected true but got %v, %v", pw, ok, err)
+		}
+	}
+
+	for _, pw := range []string{"not-breached", "filler-2000", "Password"} {
+		if ok, err := password.IsBreached(filename, pw); err != nil || ok {
+			t.Errorf("IsBreached(%q): expected false but got %v, %v", pw, ok, err)
+		}
+	}
+
+	if _, err := password.IsBreached(filepath.Join(t.TempDir(), "missing.txt"), "password"); err == nil {
+		t.Errorf("IsBreached: expected an error for a missing file")
+	}
+}
+
+func TestHash(t *testing.T) {
+	var (
+		bcrypt4 = password.HashParams{Scheme: password.SchemeBcrypt, BcryptCost: 4}
+		bcrypt5 = password.HashParams{Scheme: password.SchemeBcrypt, BcryptCost: 5}
+		argon   = password.HashParams{
+			Scheme:          password.SchemeArgon2id,
+			Argon2Time:      1,
+			Argon2Memory:    1024,
+			Argon2Threads:   1,
+			Argon2KeyLength: 32,
+			Argon2SaltSize:  16,
+		}
+		argonStronger = argon
+	)
+	argonStronger.Argon2Time = 2
+
+	var tests = []struct {
+		Hash    password.HashParams
+		Current password.HashParams
+		Prefix  string
+		Rehash  bool
+	}{
+		{bcrypt4, bcrypt4, "$2a$04$", false},
+		{bcrypt4, bcrypt5, "$2a$04$", true},
+		{bcrypt5, bcrypt4, "$2a$05$", false},
+		{bcrypt4, argon, "$2a$04$", true},
+		{argon, argon, "$argon2id$v=19$m=1024,t=1,p=1$", false},
+		{argon, argonStronger, "$argon2id$v=19$m=1024,t=1,p=1$", true},
+		{argon, bcrypt4, "$argon2id$", true},
+	}
+
+	for i, test := range tests {
+		hash, err := test.Hash.Hash("secret")
+		if err != nil {
+			t.Errorf("test %d: Hash: %s", i, err)
+			continue
+		}
+
+		if !strings.HasPrefix(hash, test.Prefix) {
+			t.Errorf("test %d: expected hash to begin with %s but got %s", i, test.Prefix, hash)
+		}
+		if password.Describe(hash) != test.Hash.String() {
+			t.Errorf("test %d: Describe: expected %s but got %s", i, test.Hash, password.Describe(hash))
+		}
+
+		if err := password.Verify(hash, "secret"); err != nil {
+			t.Errorf("test %d: Verify with the right password: %s", i, err)
+		}
+		if err := password.Verify(hash, "Secret"); err != password.ErrMismatch {
+			t.Errorf("test %d: Verify with the wrong password: expected ErrMismatch but got %v", i, err)
+		}
+
+		if actual := test.Current.NeedsRehash(hash); actual != test.Rehash {
+			t.Errorf("test %d: NeedsRehash(%s) under %s: expected %v but got %v", i, test.Hash, test.Current, test.Rehash, actual)
+		}
+	}
+
+	if err := password.Verify("not a hash", "secret"); err == nil {
+		t.Errorf("Verify: expected an error for an unrecognized hash")
+	}
+}
@@ -0,0 +1,85 @@
+package password
+
+import (
+	"math"
+	"unicode"
+)
+
+// Strength estimates how hard a password is to guess, from 0 (trivial) to 4 (very strong).
+//
+// This is a rough estimate based on Entropy; it doesn't know about dictionary words, which is
+// what the breached password file is for.
+func Strength(password string) int {
+	var bits = Entropy(password)
+	switch {
+	case bits < 28:
+		return 0
+	case bits < 36:
+		return 1
+	case bits < 60:
+		return 2
+	case bits < 80:
+		return 3
+	default:
+		return 4
+	}
+}
+
+// Entropy estimates the bits of entropy in a password.
+//
+// Each character contributes the bits of the character pool it was drawn from (lowercase,
+// uppercase, digits, symbols), discounted when it repeats an earlier character or continues a
+// sequence like "abc" or "321".
+func Entropy(password string) float64 {
+	var (
+		lower, upper, digit, symbol, other bool
+		seen                               = map[rune]int{}
+		length                             float64
+		prev                               rune
+	)
+
+	for i, c := range password {
+		switch {
+		case c < unicode.MaxASCII && unicode.IsLower(c):
+			lower = true
+		case c < unicode.MaxASCII && unicode.IsUpper(c):
+			upper = true
+		case c < unicode.MaxASCII && unicode.IsDigit(c):
+			digit = true
+		case c < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+
+		seen[c]++
+		var weight = 1 / float64(seen[c])
+		if i > 0 && (c == prev+1 || c == prev-1) {
+			weight /= 2
+		}
+		length += weight
+		prev = c
+	}
+
+	var pool float64
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	return length * math.Log2(pool)
+}
@@ -0,0 +1,155 @@
+// Package pow implements a hashcash-style proof of work that browsers solve before submitting
+// public forms, to slow down bots without a third-party captcha.
+//
+// A challenge carries its expiration time and difficulty and is signed with an HMAC, so the
+// server needn't remember the challenges it hands out. The browser (web/static/js/pow.js) looks
+// for a nonce such that the SHA-256 hash of "challenge:nonce" begins with that many zero bits.
+// A solved challenge is remembered in Redis until it expires, so it can only be used once.
+package pow
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/redis"
+)
+
+// Errors from Verify.
+var (
+	ErrMissing  = errors.New("the anti-spam check did not run; please make sure JavaScript is enabled")
+	ErrInvalid  = errors.New("the anti-spam check is not valid")
+	ErrExpired  = errors.New("the anti-spam check has expired")
+	ErrUnsolved = errors.New("the anti-spam check was not solved")
+	ErrReused   = errors.New("the anti-spam check has already been used")
+)
+
+// Enabled says whether forms should carry a challenge, by the configured difficulty.
+func Enabled() bool {
+	return config.Current.ProofOfWork.Difficulty > 0
+}
+
+// NewChallenge makes a signed challenge at the configured difficulty.
+func NewChallenge() (string, error) {
+	var settings = config.Current.ProofOfWork
+	return newChallenge(time.Now().Add(settings.Expires), settings.Difficulty)
+}
+
+func newChallenge(expires time.Time, difficulty int) (string, error) {
+	var buf = make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	payload := fmt.Sprintf("%d.%d.%s", expires.Unix(), difficulty, hex.EncodeToString(buf))
+	return payload + "." + sign(payload), nil
+}
+
+// Difficulty of a challenge: the number of leading zero bits a solution needs.
+func Difficulty(challenge string) int {
+	if parts := strings.Split(challenge, "."); len(parts) == 4 {
+		if difficulty, err := strconv.Atoi(parts[1]); err == nil {
+			return difficulty
+		}
+	}
+	return 0
+}
+
+// Verify a solution to a challenge, and burn the challenge so it can't be used again.
+func Verify(challenge, nonce string) error {
+	if challenge == "" || nonce == "" {
+		return ErrMissing
+	}
+
+	// Check the signature before trusting anything in the challenge.
+	parts := strings.Split(challenge, ".")
+	if len(parts) != 4 || len(nonce) > config.ProofOfWorkMaxNonce {
+		return ErrInvalid
+	}
+	payload := strings.Join(parts[:3], ".")
+	if !hmac.Equal([]byte(parts[3]), []byte(sign(payload))) {
+		return ErrInvalid
+	}
+
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ErrInvalid
+	}
+	difficulty, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return ErrInvalid
+	}
+
+	ttl := time.Until(time.Unix(expires, 0))
+	if ttl <= 0 {
+		return ErrExpired
+	}
+
+	if !Solves(challenge, nonce, difficulty) {
+		return ErrUnsolved
+	}
+
+	// Only once, even if the same solution is posted several times at once.
+	ok, err := redis.SetNX(fmt.Sprintf(config.ProofOfWorkRedisKey, parts[2]), true, ttl)
+	if err != nil {
+		return err
+	} else if !ok {
+		return ErrReused
+	}
+
+	return nil
+}
+
+// VerifyRequest checks the solution posted with a form (the inputs from the ProofOfWork template
+// function). It passes when proof of work is disabled.
+func VerifyRequest(r *http.Request) error {
+	if !Enabled() {
+		return nil
+	}
+	return Verify(r.FormValue(config.ProofOfWorkInputName), r.FormValue(config.ProofOfWorkNonceInputName))
+}
+
+// Solves checks whether the nonce solves the challenge at a difficulty.
+func Solves(challenge, nonce string, difficulty int) bool {
+	sum := sha256.Sum256([]byte(challenge + ":" + nonce))
+	return leadingZeroBits(sum[:]) >= difficulty
+}
+
+// Solve a challenge by brute force, as the browser does. This is for tests and scripts.
+func Solve(challenge string) string {
+	var difficulty = Difficulty(challenge)
+	for i := 0; ; i++ {
+		nonce := strconv.Itoa(i)
+		if Solves(challenge, nonce, difficulty) {
+			return nonce
+		}
+	}
+}
+
+// leadingZeroBits counts the zero bits at the start of a hash.
+func leadingZeroBits(sum []byte) int {
+	var count int
+	for _, b := range sum {
+		if b != 0 {
+			return count + bits.LeadingZeros8(b)
+		}
+		count += 8
+	}
+	return count
+}
+
+// sign a challenge payload with the configured secret.
+func sign(payload string) string {
+	mac := hmac.New(sha256.New, []byte(config.Current.ProofOfWork.Secret))
+	mac.Write([]byte(payload))
+	return hex.EncodeToString(mac.Sum(nil))
+}
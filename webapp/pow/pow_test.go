@@ -0,0 +1,71 @@
+package pow_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/pow"
+)
+
+func TestVerify(t *testing.T) {
+	config.Current.ProofOfWork = config.ProofOfWork{
+		Difficulty: 8,
+		Secret:     "test secret",
+		Expires:    time.Minute,
+	}
+
+	challenge, err := pow.NewChallenge()
+	if err != nil {
+		t.Fatalf("NewChallenge: %s", err)
+	}
+	if pow.Difficulty(challenge) != 8 {
+		t.Errorf("expected difficulty 8 but got %d", pow.Difficulty(challenge))
+	}
+
+	nonce := pow.Solve(challenge)
+
+	// A wrong nonce; one in 256 would solve it by chance, so find one that doesn't.
+	var wrong = "x"
+	for pow.Solves(challenge, wrong, 8) {
+		wrong += "x"
+	}
+
+	// Tampering with any part of the challenge breaks the signature.
+	parts := strings.Split(challenge, ".")
+	easier := strings.Join([]string{parts[0], "0", parts[2], parts[3]}, ".")
+
+	var tests = []struct {
+		Challenge string
+		Nonce     string
+		Expect    error
+	}{
+		{"", "", pow.ErrMissing},
+		{challenge, "", pow.ErrMissing},
+		{"junk", "1", pow.ErrInvalid},
+		{easier, "0", pow.ErrInvalid},
+		{challenge, strings.Repeat("1", 100), pow.ErrInvalid},
+		{challenge, wrong, pow.ErrUnsolved},
+		{challenge, nonce, nil},
+		{challenge, nonce, pow.ErrReused},
+	}
+	for _, test := range tests {
+		if err := pow.Verify(test.Challenge, test.Nonce); err != test.Expect {
+			t.Errorf("Verify(%q, %q): expected %v but got %v", test.Challenge, test.Nonce, test.Expect, err)
+		}
+	}
+
+	// A challenge signed with another secret.
+	config.Current.ProofOfWork.Secret = "another secret"
+	if err := pow.Verify(challenge, nonce); err != pow.ErrInvalid {
+		t.Errorf("expected a challenge from another secret to be invalid, got %v", err)
+	}
+
+	// Expired challenges.
+	config.Current.ProofOfWork.Expires = -time.Second
+	challenge, _ = pow.NewChallenge()
+	if err := pow.Verify(challenge, pow.Solve(challenge)); err != pow.ErrExpired {
+		t.Errorf("expected an expired challenge, got %v", err)
+	}
+}
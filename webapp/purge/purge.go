@@ -0,0 +1,176 @@
+// Package purge deletes user accounts at their owner's request, after a grace period during which
+// the request can be cancelled (by a link we email them, or by an admin).
+package purge
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/mail"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/models/deletion"
+	"github.com/aichaos/silhouette/webapp/redis"
+	"github.com/aichaos/silhouette/webapp/router"
+	"github.com/aichaos/silhouette/webapp/session"
+)
+
+// CancelToken goes in Redis, for the emailed link to cancel a deletion.
+type CancelToken struct {
+	UserID uint64
+	Token  string
+}
+
+// Delete the token.
+func (t CancelToken) Delete() error {
+	return redis.Delete(fmt.Sprintf(config.CancelDeletionRedisKey, t.Token))
+}
+
+// GetCancelToken looks up the token from a cancel link.
+func GetCancelToken(token string) (CancelToken, error) {
+	var t CancelToken
+	if err := redis.Get(fmt.Sprintf(config.CancelDeletionRedisKey, token), &t); err != nil || t.Token != token {
+		return t, errors.New("invalid or expired link")
+	}
+	return t, nil
+}
+
+// Request deletion of a user's account: it is marked pending deletion, they are signed out
+// everywhere and emailed a link to cancel. If there is no grace period in the settings, the account
+// is deleted right away and the returned request is nil.
+func Request(user *models.User) (*models.DeletionRequest, error) {
+	var graceDays = config.Current.Deletion.GraceDays
+	if graceDays <= 0 {
+		return nil, deletion.DeleteUser(user)
+	}
+
+	d, err := user.RequestDeletion(time.Now().AddDate(0, 0, graceDays))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := session.RevokeAllSessions(user.ID, ""); err != nil {
+		log.Error("purge.Request: couldn't revoke sessions for %s: %s", user.Username, err)
+	}
+
+	// The link to cancel lasts as long as the grace period.
+	token := CancelToken{
+		UserID: user.ID,
+		Token:  uuid.New().String(),
+	}
+	if err := redis.SetUserToken(user.ID, fmt.Sprintf(config.CancelDeletionRedisKey, token.Token), token, time.Until(d.PurgeAt)); err != nil {
+		log.Error("purge.Request: couldn't create a cancel link for %s: %s", user.Username, err)
+		return d, nil
+	}
+
+	if err := mail.Send(mail.Message{
+		To:       user.Email,
+		Subject:  "Your account will be deleted",
+		Template: "email/deletion_requested.html",
+		Data: map[string]interface{}{
+			"Title":    config.Title,
+			"Username": user.Username,
+			"Date":     Date(d),
+			"URL":      router.MustAbsoluteURLFor("account.cancel_deletion", "token", token.Token),
+		},
+	}); err != nil {
+		log.Error("purge.Request: couldn't email %s: %s", user.Username, err)
+	}
+
+	return d, nil
+}
+
+// Cancel a deletion request, making the account active again.
+func Cancel(user *models.User) error {
+	if user.Status != models.UserStatusPendingDeletion {
+		return errors.New("the account is not pending deletion")
+	}
+	return user.CancelDeletion()
+}
+
+// Purge an account pending deletion now, and email its owner that it's gone.
+func Purge(user *models.User) error {
+	if user.Status != models.UserStatusPendingDeletion {
+		return errors.New("the account is not pending deletion")
+	}
+
+	if err := deletion.DeleteUser(user); err != nil {
+		return err
+	}
+
+	if err := mail.Send(mail.Message{
+		To:       user.Email,
+		Subject:  "Your account has been deleted",
+		Template: "email/account_deleted.html",
+		Data: map[string]interface{}{
+			"Title":    config.Title,
+			"Username": user.Username,
+		},
+	}); err != nil {
+		log.Error("purge.Purge: couldn't email %s: %s", user.Username, err)
+	}
+
+	return nil
+}
+
+// Due purges the accounts whose grace period has run out, and returns how many were deleted.
+func Due() (int, error) {
+	requests, err := models.GetDueDeletionRequests()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	for _, d := range requests {
+		user, err := models.GetUser(d.UserID)
+		if err != nil || user.Status != models.UserStatusPendingDeletion {
+			// Gone already, or an admin has since changed the account's status.
+			log.Warn("purge.Due: dropping stale deletion request for user %d", d.UserID)
+			if err := models.DeleteDeletionRequest(d.UserID); err != nil {
+				log.Error("purge.Due: couldn't drop deletion request for user %d: %s", d.UserID, err)
+			}
+			continue
+		}
+
+		if err := Purge(user); err != nil {
+			log.Error("purge.Due: couldn't delete %s: %s", user.Username, err)
+			continue
+		}
+		count++
+
+		if err := models.CreateAuditLog(&models.AuditLog{
+			Action:         models.AuditDeletionPurged,
+			TargetID:       user.ID,
+			TargetUsername: user.Username,
+			Before:         models.UserStatusPendingDeletion,
+		}); err != nil {
+			log.Error("purge.Due: couldn't record deletion of %s: %s", user.Username, err)
+		}
+		log.Info("purge.Due: deleted %s, whose grace period ran out", user.Username)
+	}
+
+	return count, nil
+}
+
+// Start purging accounts in the background every config.DeletionPurgeInterval.
+func Start() {
+	go func() {
+		ticker := time.NewTicker(config.DeletionPurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			if _, err := Due(); err != nil {
+				log.Error("purge.Start: %s", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+// Date an account pending deletion will be purged, for messages to the user.
+func Date(d *models.DeletionRequest) string {
+	return d.PurgeAt.Format("Jan _2 2006 15:04 MST")
+}
@@ -0,0 +1,151 @@
+package ratelimit
+
+import (
+	"github.com/aichaos/silhouette/webapp/redis"
+)
+
+// Algorithm for counting hits against a Limiter.
+type Algorithm string
+
+// Supported algorithms.
+const (
+	// FixedWindow allows Limit hits per Window, starting from the first hit. Cheap, but allows
+	// bursts of up to 2x the limit across the edge of two windows.
+	FixedWindow Algorithm = "fixed-window"
+
+	// SlidingWindow keeps a log of hit times and allows Limit hits in any span of Window. Exact,
+	// but stores every hit.
+	SlidingWindow Algorithm = "sliding-window"
+
+	// GCRA (generic cell rate algorithm, a token bucket) allows bursts of up to Limit hits, then
+	// one more hit each Window/Limit as the bucket refills. Exact, and stores one number.
+	GCRA Algorithm = "gcra"
+)
+
+// Lua scripts for each algorithm, run atomically in Redis.
+//
+// KEYS: the algorithm's key and the cooldown key.
+// ARGV: now (ms), window (ms), limit, cooldown at, cooldown (ms), unique nonce, peek (1 to only
+// check, without counting the hit).
+// Returns: {allowed (0 or 1), remaining, reset (ms), retry after (ms), reason}
+var algorithms = map[Algorithm]*redis.Script{
+	FixedWindow: redis.NewScript(luaPrelude + `
+local used = tonumber(redis.call("GET", key) or "0")
+local reset = redis.call("PTTL", key)
+if reset < 0 then
+	redis.call("DEL", key)
+	used, reset = 0, window
+end
+
+if used >= limit then
+	return {0, 0, reset, reset, "limit"}
+end
+
+local wait = cooldown_left()
+if wait > 0 then
+	return {0, limit - used, reset, wait, "cooldown"}
+end
+
+if peek then
+	return {1, limit - used, reset, 0, ""}
+end
+
+used = redis.call("INCR", key)
+if used == 1 then
+	redis.call("PEXPIRE", key, window)
+end
+return counted(used, redis.call("PTTL", key))
+`),
+
+	SlidingWindow: redis.NewScript(luaPrelude + `
+redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+local used = redis.call("ZCARD", key)
+
+local function reset_in()
+	local newest = redis.call("ZRANGE", key, -1, -1, "WITHSCORES")
+	if #newest == 0 then
+		return 0
+	end
+	return tonumber(newest[2]) + window - now
+end
+
+if used >= limit then
+	local oldest = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
+	return {0, 0, reset_in(), tonumber(oldest[2]) + window - now, "limit"}
+end
+
+local wait = cooldown_left()
+if wait > 0 then
+	return {0, limit - used, reset_in(), wait, "cooldown"}
+end
+
+if peek then
+	return {1, limit - used, reset_in(), 0, ""}
+end
+
+redis.call("ZADD", key, now, now .. ":" .. ARGV[6])
+redis.call("PEXPIRE", key, window)
+return counted(used + 1, window)
+`),
+
+	GCRA: redis.NewScript(luaPrelude + `
+local interval = window / limit
+local tat = tonumber(redis.call("GET", key) or "0")
+if tat < now then
+	tat = now
+end
+
+local new_tat = tat + interval
+local allow_at = new_tat - window
+if allow_at > now then
+	return {0, 0, math.ceil(tat - now), math.ceil(allow_at - now), "limit"}
+end
+
+-- Hits still in the bucket (the epsilon guards against float error).
+local function in_bucket(t)
+	return math.ceil((t - now) / interval - 1e-9)
+end
+
+local wait = cooldown_left()
+if wait > 0 then
+	return {0, limit - in_bucket(tat), math.ceil(tat - now), wait, "cooldown"}
+end
+
+if peek then
+	return {1, limit - in_bucket(tat), math.ceil(tat - now), 0, ""}
+end
+
+redis.call("SET", key, new_tat, "PX", math.ceil(new_tat - now))
+return counted(in_bucket(new_tat), math.ceil(new_tat - now))
+`),
+}
+
+// Shared by all the algorithm scripts: parse the arguments, and handle the cooldown (a forced
+// wait between hits once there have been more than CooldownAt of them).
+const luaPrelude = `
+local key, cool_key = KEYS[1], KEYS[2]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+local cooldown_at = tonumber(ARGV[4])
+local cooldown = tonumber(ARGV[5])
+local peek = ARGV[7] == "1"
+
+-- How long (ms) until a cooldown ends, or 0.
+local function cooldown_left()
+	local ttl = redis.call("PTTL", cool_key)
+	if ttl > 0 then
+		return ttl
+	end
+	return 0
+end
+
+-- After counting a hit: start a cooldown if there have been too many.
+local function counted(used, reset)
+	if cooldown_at > 0 and used > cooldown_at and used < limit then
+		redis.call("SET", cool_key, "1", "PX", cooldown)
+		return {0, limit - used, reset, cooldown, "cooldown"}
+	end
+	return {1, limit - used, reset, 0, ""}
+end
+`
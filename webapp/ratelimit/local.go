@@ -0,0 +1,190 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/redis"
+)
+
+// The memory and SQLite cache stores can't run the Lua scripts, so for them the algorithms run
+// here instead. Those stores only serve a single process, so a lock here keeps hits atomic.
+var localMu sync.Mutex
+
+// Stored values for the algorithms run locally.
+type (
+	fixedWindowData struct {
+		Pings   int
+		Expires time.Time
+	}
+	slidingWindowData struct {
+		Hits []time.Time
+	}
+	gcraData struct {
+		TAT time.Time // theoretical arrival time: when the bucket will be full again
+	}
+	cooldownData struct {
+		NotBefore time.Time
+	}
+)
+
+// hitLocal does the same as the Lua scripts in algorithms.go.
+func (l *Limiter) hitLocal(algorithm Algorithm, now time.Time, peek bool) (Decision, error) {
+	localMu.Lock()
+	defer localMu.Unlock()
+
+	var (
+		key      = l.key(string(algorithm))
+		coolKey  = l.key("cooldown")
+		cooldown cooldownData
+		decision = Decision{
+			Limit: l.Limit,
+		}
+	)
+
+	if err := getLocal(coolKey, &cooldown); err != nil {
+		return l.failed(err)
+	}
+
+	// refuse a hit: over the limit, or cooling down.
+	refuse := func(reason Reason, remaining int, resetAt time.Time, retryAfter time.Duration) (Decision, error) {
+		decision.Reason = reason
+		decision.Remaining = remaining
+		decision.ResetAt = resetAt
+		decision.RetryAfter = retryAfter
+		return decision, nil
+	}
+
+	// allow a peek without counting it.
+	allow := func(remaining int, resetAt time.Time) (Decision, error) {
+		decision.Allowed = true
+		decision.Remaining = remaining
+		decision.ResetAt = resetAt
+		return decision, nil
+	}
+
+	// counted a hit: start a cooldown if there have been too many.
+	counted := func(used int, resetAt time.Time) (Decision, error) {
+		decision.Allowed = true
+		decision.Remaining = l.Limit - used
+		decision.ResetAt = resetAt
+		if l.CooldownAt > 0 && used > l.CooldownAt && used < l.Limit {
+			if err := redis.Set(coolKey, cooldownData{NotBefore: now.Add(l.Cooldown)}, l.Cooldown); err != nil {
+				return l.failed(err)
+			}
+			decision.Allowed = false
+			decision.Reason = ReasonCooldown
+			decision.RetryAfter = l.Cooldown
+		}
+		return decision, nil
+	}
+
+	switch algorithm {
+	case FixedWindow:
+		var data fixedWindowData
+		if err := getLocal(key, &data); err != nil {
+			return l.failed(err)
+		}
+		if !now.Before(data.Expires) {
+			data = fixedWindowData{Expires: now.Add(l.Window)}
+		}
+
+		if data.Pings >= l.Limit {
+			return refuse(ReasonLimit, 0, data.Expires, data.Expires.Sub(now))
+		}
+		if now.Before(cooldown.NotBefore) {
+			return refuse(ReasonCooldown, l.Limit-data.Pings, data.Expires, cooldown.NotBefore.Sub(now))
+		}
+		if peek {
+			return allow(l.Limit-data.Pings, data.Expires)
+		}
+
+		data.Pings++
+		if err := redis.Set(key, data, data.Expires.Sub(now)); err != nil {
+			return l.failed(err)
+		}
+		return counted(data.Pings, data.Expires)
+
+	case SlidingWindow:
+		var data slidingWindowData
+		if err := getLocal(key, &data); err != nil {
+			return l.failed(err)
+		}
+
+		// Forget hits that have slid out of the window.
+		var hits = []time.Time{}
+		for _, hit := range data.Hits {
+			if hit.After(now.Add(-l.Window)) {
+				hits = append(hits, hit)
+			}
+		}
+		data.Hits = hits
+
+		var resetAt = now
+		if len(hits) > 0 {
+			resetAt = hits[len(hits)-1].Add(l.Window)
+		}
+
+		if len(hits) >= l.Limit {
+			return refuse(ReasonLimit, 0, resetAt, hits[0].Add(l.Window).Sub(now))
+		}
+		if now.Before(cooldown.NotBefore) {
+			return refuse(ReasonCooldown, l.Limit-len(hits), resetAt, cooldown.NotBefore.Sub(now))
+		}
+		if peek {
+			return allow(l.Limit-len(hits), resetAt)
+		}
+
+		data.Hits = append(data.Hits, now)
+		if err := redis.Set(key, data, l.Window); err != nil {
+			return l.failed(err)
+		}
+		return counted(len(data.Hits), now.Add(l.Window))
+
+	case GCRA:
+		var data gcraData
+		if err := getLocal(key, &data); err != nil {
+			return l.failed(err)
+		}
+
+		var (
+			interval = l.Window / time.Duration(l.Limit)
+			tat      = data.TAT
+		)
+		if tat.Before(now) {
+			tat = now
+		}
+
+		inBucket := func(t time.Time) int {
+			return int(math.Ceil(float64(t.Sub(now))/float64(interval) - 1e-9))
+		}
+
+		var newTAT = tat.Add(interval)
+		if allowAt := newTAT.Add(-l.Window); allowAt.After(now) {
+			return refuse(ReasonLimit, 0, tat, allowAt.Sub(now))
+		}
+		if now.Before(cooldown.NotBefore) {
+			return refuse(ReasonCooldown, l.Limit-inBucket(tat), tat, cooldown.NotBefore.Sub(now))
+		}
+		if peek {
+			return allow(l.Limit-inBucket(tat), tat)
+		}
+
+		if err := redis.Set(key, gcraData{TAT: newTAT}, newTAT.Sub(now)); err != nil {
+			return l.failed(err)
+		}
+		return counted(inBucket(newTAT), newTAT)
+	}
+
+	return l.failed(fmt.Errorf("unknown rate limit algorithm %q", algorithm))
+}
+
+// getLocal reads a stored value, where a missing one is not an error.
+func getLocal(key string, v interface{}) error {
+	if err := redis.Get(key, v); err != nil && err != redis.ErrNotFound {
+		return err
+	}
+	return nil
+}
@@ -1,15 +1,21 @@
 package ratelimit
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
 	"github.com/aichaos/silhouette/webapp/redis"
 	"github.com/aichaos/silhouette/webapp/utility"
+	"github.com/google/uuid"
 )
 
 // Limiter implements a Redis-backed rate limit for logins or otherwise.
+//
+// With Redis the algorithms run atomically as Lua scripts; with the memory or SQLite cache stores
+// they run in-process (see local.go).
 type Limiter struct {
 	Namespace  string        // kind of rate limiter ("login")
 	ID         interface{}   // unique ID of the resource being pinged (str or ints)
@@ -17,70 +23,209 @@ type Limiter struct {
 	Window     time.Duration // the window period/expiration of Redis key
 	CooldownAt int           // how many pings before the cooldown is enforced
 	Cooldown   time.Duration // time to wait between fails
+	Algorithm  Algorithm     // default FixedWindow
+	OnError    Policy        // when Redis fails: default FailClosed
 }
 
-// Redis object behind the rate limiter.
-type Data struct {
-	Pings     int
-	NotBefore time.Time
+// Policy for when the limiter can't reach Redis.
+type Policy int
+
+// Failure policies.
+const (
+	FailClosed Policy = iota // deny the hit
+	FailOpen                 // allow the hit
+)
+
+// Reason a hit was not allowed.
+type Reason string
+
+// Reasons.
+const (
+	ReasonLimit    Reason = "limit"    // locked out until the limit resets
+	ReasonCooldown Reason = "cooldown" // throttled: must wait between hits
+	ReasonError    Reason = "error"    // Redis failed and the limiter fails closed
+)
+
+// Decision about a hit on the rate limiter.
+type Decision struct {
+	Allowed    bool
+	Reason     Reason // why it's not allowed
+	Limit      int
+	Remaining  int
+	ResetAt    time.Time     // when all hits so far will have expired
+	RetryAfter time.Duration // when not allowed: how long until the next hit may be
 }
 
-// Ping the rate limiter.
-func (l *Limiter) Ping() error {
+// Error returned by Ping when a hit was not allowed, with a user-facing message.
+type Error struct {
+	Decision Decision
+	Message  string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Hit counts one hit against the limiter and decides whether it's allowed.
+//
+// A Redis error is returned along with a decision made by the OnError policy. A hit that starts a
+// cooldown (the CooldownAt'th and later) is counted, but not allowed.
+func (l *Limiter) Hit() (Decision, error) {
+	return l.run(false)
+}
+
+// Peek decides whether a hit would be allowed right now, without counting one. It's refused only
+// while a cooldown is running or the limit has been reached.
+func (l *Limiter) Peek() (Decision, error) {
+	return l.run(true)
+}
+
+// run the limiter's algorithm, counting the hit unless peeking.
+func (l *Limiter) run(peek bool) (Decision, error) {
 	var (
-		key = l.Key()
-		now = time.Now()
+		algorithm = l.algorithm()
+		now       = time.Now()
 	)
 
-	// Get stored data from Redis if any.
-	var data Data
-	redis.Get(key, &data)
-
-	// Are we cooling down?
-	if now.Before(data.NotBefore) {
-		return fmt.Errorf(
-			"You are doing that too often. Please wait %s before trying again.",
-			utility.FormatDurationCoarse(data.NotBefore.Sub(now)),
-		)
+	script, ok := algorithms[algorithm]
+	if !ok {
+		return l.failed(fmt.Errorf("unknown rate limit algorithm %q", algorithm))
+	} else if l.Limit <= 0 || l.Window <= 0 {
+		return l.failed(errors.New("rate limit and window must be positive"))
 	}
 
-	// Increment the ping count.
-	data.Pings++
+	res, err := script.Run(
+		[]string{l.key(string(algorithm)), l.key("cooldown")},
+		now.UnixMilli(),
+		l.Window.Milliseconds(),
+		l.Limit,
+		l.CooldownAt,
+		l.Cooldown.Milliseconds(),
+		uuid.New().String(),
+		peek,
+	)
+	if err == redis.ErrScriptsUnsupported {
+		return l.hitLocal(algorithm, now, peek)
+	} else if err != nil {
+		return l.failed(fmt.Errorf("rate limiter script: %s", err))
+	}
 
-	// Have we hit the wall?
-	if data.Pings >= l.Limit {
-		return fmt.Errorf(
-			"You have hit the rate limit; please wait the full %s before trying again.",
-			utility.FormatDurationCoarse(l.Window),
-		)
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 5 {
+		return l.failed(fmt.Errorf("rate limiter script: unexpected result %v", res))
 	}
 
-	// Are we throttled?
-	if l.CooldownAt > 0 && data.Pings > l.CooldownAt {
-		data.NotBefore = now.Add(l.Cooldown)
-		if err := redis.Set(key, data, l.Window); err != nil {
-			return fmt.Errorf("Couldn't set Redis key for rate limiter: %s", err)
+	var ints = make([]int64, 4)
+	for i := range ints {
+		if ints[i], ok = values[i].(int64); !ok {
+			return l.failed(fmt.Errorf("rate limiter script: unexpected result %v", res))
 		}
-		return fmt.Errorf(
+	}
+	reason, _ := values[4].(string)
+
+	return Decision{
+		Allowed:    ints[0] == 1,
+		Reason:     Reason(reason),
+		Limit:      l.Limit,
+		Remaining:  int(ints[1]),
+		ResetAt:    now.Add(time.Duration(ints[2]) * time.Millisecond),
+		RetryAfter: time.Duration(ints[3]) * time.Millisecond,
+	}, nil
+}
+
+// Ping the rate limiter, e.g. after a failed login attempt. Returns an *Error with a message for
+// the user if they have gone over the limit.
+func (l *Limiter) Ping() error {
+	decision, err := l.Hit()
+	if err != nil {
+		log.Error("ratelimit.Ping(%s): %s", l.Key(), err)
+	}
+	return l.refusal(decision)
+}
+
+// Check the rate limiter without pinging it, e.g. before trying a login attempt that only counts
+// if it fails. Returns an *Error like Ping does if the user must wait.
+func (l *Limiter) Check() error {
+	decision, err := l.Peek()
+	if err != nil {
+		log.Error("ratelimit.Check(%s): %s", l.Key(), err)
+	}
+	return l.refusal(decision)
+}
+
+// refusal returns the *Error for a decision, or nil if it was allowed.
+func (l *Limiter) refusal(decision Decision) error {
+	if decision.Allowed {
+		return nil
+	}
+
+	var message string
+	switch decision.Reason {
+	case ReasonLimit:
+		message = fmt.Sprintf(
+			"You have hit the rate limit; please wait the full %s before trying again.",
+			utility.FormatDurationCoarse(decision.RetryAfter),
+		)
+	case ReasonCooldown:
+		message = fmt.Sprintf(
 			"Please wait %s before trying again. You have %d more attempt(s) remaining before you will be locked "+
 				"out for %s.",
-			utility.FormatDurationCoarse(l.Cooldown),
-			l.Limit-data.Pings,
+			utility.FormatDurationCoarse(decision.RetryAfter),
+			decision.Remaining,
 			utility.FormatDurationCoarse(l.Window),
 		)
+	default:
+		message = "Couldn't check the rate limit; please try again later."
 	}
 
-	// Save their ping count to Redis.
-	if err := redis.Set(key, data, l.Window); err != nil {
-		return fmt.Errorf("Couldn't set Redis key for rate limiter: %s", err)
+	return &Error{
+		Decision: decision,
+		Message:  message,
 	}
-
-	return nil
 }
 
+// The suffixes of a limiter's Redis keys.
+var suffixes = []string{string(FixedWindow), string(SlidingWindow), string(GCRA), "cooldown"}
+
 // Clear the rate limiter, cleaning up the Redis key (e.g., after successful login).
 func (l *Limiter) Clear() error {
-	return redis.Delete(l.Key())
+	for _, suffix := range suffixes {
+		if err := redis.Delete(l.key(suffix)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Active says whether the limiter has anything in Redis, i.e. it was hit recently.
+func (l *Limiter) Active() bool {
+	for _, suffix := range suffixes {
+		if redis.Exists(l.key(suffix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// CountActive returns how many of the limiters are active.
+func CountActive(limiters []*Limiter) int64 {
+	var count int64
+	for _, l := range limiters {
+		if l.Active() {
+			count++
+		}
+	}
+	return count
+}
+
+// ClearAll clears the limiters (e.g., those of a deleted user).
+func ClearAll(limiters []*Limiter) error {
+	for _, l := range limiters {
+		if err := l.Clear(); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Key formats the Redis key.
@@ -102,3 +247,33 @@ func (l *Limiter) Key() string {
 	}
 	return fmt.Sprintf(config.RateLimitRedisKey, l.Namespace, str)
 }
+
+// key for one of the limiter's Redis values. Each algorithm has its own, in case it's changed.
+func (l *Limiter) key(suffix string) string {
+	return l.Key() + ":" + suffix
+}
+
+func (l *Limiter) algorithm() Algorithm {
+	if l.Algorithm == "" {
+		return FixedWindow
+	}
+	return l.Algorithm
+}
+
+// failed makes the decision for when the limiter couldn't do its job.
+func (l *Limiter) failed(err error) (Decision, error) {
+	var decision = Decision{
+		Limit:   l.Limit,
+		ResetAt: time.Now(),
+	}
+
+	if l.OnError == FailOpen {
+		decision.Allowed = true
+		decision.Remaining = l.Limit
+	} else {
+		decision.Reason = ReasonError
+		decision.RetryAfter = time.Minute
+	}
+
+	return decision, err
+}
@@ -0,0 +1,152 @@
+package ratelimit_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/ratelimit"
+)
+
+// These run against the default in-memory cache store.
+
+func TestAlgorithms(t *testing.T) {
+	for _, algorithm := range []ratelimit.Algorithm{ratelimit.FixedWindow, ratelimit.SlidingWindow, ratelimit.GCRA} {
+		limiter := &ratelimit.Limiter{
+			Namespace: "test",
+			ID:        string(algorithm),
+			Limit:     3,
+			Window:    time.Hour,
+			Algorithm: algorithm,
+		}
+
+		for i := 1; i <= 3; i++ {
+			decision, err := limiter.Hit()
+			if err != nil || !decision.Allowed {
+				t.Fatalf("%s: hit %d: expected allowed but got %+v, %v", algorithm, i, decision, err)
+			}
+			if decision.Remaining != 3-i {
+				t.Errorf("%s: hit %d: expected %d remaining but got %d", algorithm, i, 3-i, decision.Remaining)
+			}
+		}
+
+		decision, err := limiter.Hit()
+		if err != nil || decision.Allowed || decision.Reason != ratelimit.ReasonLimit {
+			t.Errorf("%s: hit 4: expected to hit the limit but got %+v, %v", algorithm, decision, err)
+		}
+		if decision.RetryAfter <= 0 || decision.RetryAfter > time.Hour {
+			t.Errorf("%s: hit 4: unexpected RetryAfter %s", algorithm, decision.RetryAfter)
+		}
+
+		// Clearing it lets them back in.
+		limiter.Clear()
+		if decision, _ := limiter.Hit(); !decision.Allowed {
+			t.Errorf("%s: expected a hit to be allowed after Clear but got %+v", algorithm, decision)
+		}
+		limiter.Clear()
+	}
+}
+
+func TestClearAll(t *testing.T) {
+	var limiters = []*ratelimit.Limiter{
+		{Namespace: "test", ID: "active", Limit: 3, Window: time.Hour},
+		{Namespace: "test", ID: "idle", Limit: 3, Window: time.Hour},
+	}
+	limiters[0].Hit()
+
+	if count := ratelimit.CountActive(limiters); count != 1 {
+		t.Errorf("CountActive: expected 1 but got %d", count)
+	}
+	if err := ratelimit.ClearAll(limiters); err != nil {
+		t.Fatalf("ClearAll: %s", err)
+	}
+	if count := ratelimit.CountActive(limiters); count != 0 {
+		t.Errorf("CountActive after ClearAll: expected 0 but got %d", count)
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	limiter := &ratelimit.Limiter{
+		Namespace:  "test",
+		ID:         "cooldown",
+		Limit:      10,
+		Window:     time.Hour,
+		CooldownAt: 2,
+		Cooldown:   time.Minute,
+	}
+	defer limiter.Clear()
+
+	for i := 1; i <= 2; i++ {
+		if err := limiter.Ping(); err != nil {
+			t.Fatalf("ping %d: unexpected error: %s", i, err)
+		}
+	}
+
+	// The third starts a cooldown, and the fourth is refused during it.
+	for i := 3; i <= 4; i++ {
+		var rlErr *ratelimit.Error
+		if err := limiter.Ping(); !errors.As(err, &rlErr) || rlErr.Decision.Reason != ratelimit.ReasonCooldown {
+			t.Errorf("ping %d: expected a cooldown error but got %v", i, err)
+		} else if rlErr.Decision.Remaining != 7 {
+			t.Errorf("ping %d: expected 7 remaining but got %d", i, rlErr.Decision.Remaining)
+		}
+	}
+}
+
+// The way two-factor login uses a limiter: Check before trying a code, and Ping only when it's
+// wrong. A correct code must get through once the cooldown is over.
+func TestCheckAfterCooldown(t *testing.T) {
+	limiter := &ratelimit.Limiter{
+		Namespace:  "test",
+		ID:         "check",
+		Limit:      10,
+		Window:     time.Hour,
+		CooldownAt: 3,
+		Cooldown:   100 * time.Millisecond,
+	}
+	defer limiter.Clear()
+
+	// Checking doesn't count as a hit.
+	for i := 1; i <= 5; i++ {
+		if err := limiter.Check(); err != nil {
+			t.Fatalf("check %d: unexpected error: %s", i, err)
+		}
+	}
+
+	// Four wrong codes: the fourth starts a cooldown.
+	for i := 1; i <= 4; i++ {
+		limiter.Ping()
+	}
+
+	var rlErr *ratelimit.Error
+	if err := limiter.Check(); !errors.As(err, &rlErr) || rlErr.Decision.Reason != ratelimit.ReasonCooldown {
+		t.Errorf("check during the cooldown: expected a cooldown error but got %v", err)
+	}
+
+	// After the cooldown, the next (correct) code is allowed in.
+	time.Sleep(150 * time.Millisecond)
+	if err := limiter.Check(); err != nil {
+		t.Errorf("check after the cooldown: expected it to be allowed but got %s", err)
+	}
+}
+
+func TestFailurePolicy(t *testing.T) {
+	limiter := &ratelimit.Limiter{
+		Namespace: "test",
+		ID:        "broken",
+		Limit:     3,
+		Window:    time.Hour,
+		Algorithm: "no-such-algorithm",
+	}
+
+	decision, err := limiter.Hit()
+	if err == nil || decision.Allowed || decision.Reason != ratelimit.ReasonError {
+		t.Errorf("fail closed: expected a refusal with an error but got %+v, %v", decision, err)
+	}
+
+	limiter.OnError = ratelimit.FailOpen
+	decision, err = limiter.Hit()
+	if err == nil || !decision.Allowed {
+		t.Errorf("fail open: expected allowed with an error but got %+v, %v", decision, err)
+	}
+}
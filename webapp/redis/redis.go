@@ -1,49 +1,67 @@
 // Package redis provides simple Redis cache functions.
+//
+// The values live in a Store: a real Redis server by default, or for local development and
+// single-node deployments an in-process memory store or an SQLite file (see settings.toml).
 package redis
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strconv"
-	"strings"
 	"time"
 
-	"github.com/go-redis/redis/v8"
+	"github.com/aichaos/silhouette/webapp/config"
 	"github.com/aichaos/silhouette/webapp/log"
 )
 
-var ctx = context.Background()
-
-var Client *redis.Client
-
-/*
-Setup the Redis connection.
-
-The addr format is like:
+// ErrNotFound is returned by a Store when the key doesn't exist or has expired.
+var ErrNotFound = errors.New("key not found")
+
+// Store is a key/value cache with expiring keys.
+type Store interface {
+	Set(key string, value []byte, expire time.Duration) error // expire=0 for no expiration
+	SetNX(key string, value []byte, expire time.Duration) (bool, error)
+	Get(key string) ([]byte, error)
+	Exists(key string) (bool, error)
+	Delete(key string) error
+	Close() error
+
+	// Hashes: a key holding a set of fields, each of which is set or deleted atomically on its own.
+	// Setting a field (re)sets when the whole hash expires, and deleting its last field removes it.
+	HSet(key, field string, value []byte, expire time.Duration) error
+	HGetAll(key string) (map[string][]byte, error) // empty if not found
+	HDel(key string, fields ...string) error
+}
 
-- localhost:6379
-- localhost:6379/6
+// Backend is the Store in use. It's an in-memory store until Setup is called.
+var Backend Store = NewMemoryStore()
+
+// Setup the cache store chosen in settings.toml.
+func Setup(settings config.Redis) error {
+	var (
+		store Store
+		err   error
+	)
+
+	switch settings.Store {
+	case "", "redis":
+		store, err = NewRedisStore(fmt.Sprintf("%s:%d/%d", settings.Host, settings.Port, settings.DB))
+	case "memory":
+		store = NewMemoryStore()
+	case "sqlite":
+		store, err = NewSQLiteStore(settings.SQLite)
+	default:
+		err = fmt.Errorf("unknown cache store %q: choose redis, memory or sqlite", settings.Store)
+	}
 
-The latter format to specify the DB number if not the default (0).
-*/
-func Setup(addr string) error {
-	// Parse the addr string.
-	parts := strings.Split(addr, "/")
-	addr = parts[0]
-	db := 0
-	if len(parts) > 1 && len(parts[1]) > 0 {
-		a, err := strconv.Atoi(parts[1])
-		if err != nil {
-			return fmt.Errorf("redis DB number was not an integer: %s", err)
-		}
-		db = a
+	if err != nil {
+		return err
 	}
 
-	Client = redis.NewClient(&redis.Options{
-		Addr: addr,
-		DB:   db,
-	})
+	if Backend != nil {
+		Backend.Close()
+	}
+	Backend = store
 	return nil
 }
 
@@ -55,37 +73,82 @@ func Set(key string, v interface{}, expire time.Duration) error {
 	}
 
 	log.Debug("redis.Set(%s): %s", key, bin)
+	return Backend.Set(key, bin, expire)
+}
 
-	_, err = Client.Set(ctx, key, bin, expire).Result()
+// SetNX sets a JSON serializable object in Redis only if the key doesn't exist yet, atomically.
+// It returns false if the key was already there.
+func SetNX(key string, v interface{}, expire time.Duration) (bool, error) {
+	bin, err := json.Marshal(v)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	log.Debug("redis.SetNX(%s): %s", key, bin)
+	return Backend.SetNX(key, bin, expire)
 }
 
 // Get a JSON serialized value out of Redis.
 func Get(key string, v any) error {
-	val, err := Client.Get(ctx, key).Result()
+	val, err := Backend.Get(key)
 	if err != nil {
 		return err
 	}
 
 	log.Debug("redis.Get(%s): %s", key, val)
-	return json.Unmarshal([]byte(val), v)
+	return json.Unmarshal(val, v)
 }
 
 // Exists checks if a Redis key existed.
 func Exists(key string) bool {
-	val, err := Client.Exists(ctx, key).Result()
+	val, err := Backend.Exists(key)
 	if err != nil {
 		return false
 	}
-	log.Debug("redis.Exists(%s): %d", key, val)
-	return val == 1
+	log.Debug("redis.Exists(%s): %v", key, val)
+	return val
+}
+
+// HSet sets a field of a Redis hash to a JSON serializable object, and the hash to expire.
+func HSet(key, field string, v interface{}, expire time.Duration) error {
+	bin, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	log.Debug("redis.HSet(%s, %s): %s", key, field, bin)
+	return Backend.HSet(key, field, bin, expire)
+}
+
+// HGetAll gets all the fields of a Redis hash into a map (e.g. a *map[string]Thing) by field.
+func HGetAll(key string, v any) error {
+	fields, err := Backend.HGetAll(key)
+	if err != nil {
+		return err
+	}
+
+	var raw = map[string]json.RawMessage{}
+	for field, val := range fields {
+		raw[field] = val
+	}
+	bin, err := json.Marshal(raw)
+	if err != nil {
+		return err
+	}
+
+	log.Debug("redis.HGetAll(%s): %s", key, bin)
+	return json.Unmarshal(bin, v)
+}
+
+// HDel deletes fields from a Redis hash.
+func HDel(key string, fields ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	return Backend.HDel(key, fields...)
 }
 
 // Delete a key from Redis.
 func Delete(key string) error {
-	return Client.Del(ctx, key).Err()
+	return Backend.Delete(key)
 }
@@ -0,0 +1,176 @@
+package redis
+
+import (
+	"sync"
+	"time"
+)
+
+// How often the memory and SQLite stores sweep out expired keys.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps values in this process, for local development and tests. Values are lost
+// when the program exits and aren't shared between processes.
+type MemoryStore struct {
+	mu    sync.Mutex
+	items map[string]memoryItem
+	done  chan struct{}
+}
+
+type memoryItem struct {
+	value   []byte
+	fields  map[string][]byte // for a hash
+	expires time.Time         // zero for no expiration
+}
+
+func (i memoryItem) expired(now time.Time) bool {
+	return !i.expires.IsZero() && !now.Before(i.expires)
+}
+
+// NewMemoryStore creates a memory store.
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{
+		items: map[string]memoryItem{},
+		done:  make(chan struct{}),
+	}
+	go s.sweep()
+	return s
+}
+
+// Set a value.
+func (s *MemoryStore) Set(key string, value []byte, expire time.Duration) error {
+	var item = memoryItem{
+		value: append([]byte{}, value...),
+	}
+	if expire > 0 {
+		item.expires = time.Now().Add(expire)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.items[key] = item
+	return nil
+}
+
+// SetNX sets a value only if the key doesn't exist (or has expired).
+func (s *MemoryStore) SetNX(key string, value []byte, expire time.Duration) (bool, error) {
+	var item = memoryItem{
+		value: append([]byte{}, value...),
+	}
+	if expire > 0 {
+		item.expires = time.Now().Add(expire)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.items[key]; ok && !old.expired(time.Now()) {
+		return false, nil
+	}
+	s.items[key] = item
+	return true, nil
+}
+
+// Get a value.
+func (s *MemoryStore) Get(key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[key]
+	if !ok || item.expired(time.Now()) {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, item.value...), nil
+}
+
+// Exists checks if a key exists.
+func (s *MemoryStore) Exists(key string) (bool, error) {
+	_, err := s.Get(key)
+	return err == nil, nil
+}
+
+// Delete a key.
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, key)
+	return nil
+}
+
+// HSet sets a field of a hash.
+func (s *MemoryStore) HSet(key, field string, value []byte, expire time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[key]
+	if !ok || item.expired(time.Now()) || item.fields == nil {
+		item = memoryItem{
+			fields: map[string][]byte{},
+		}
+	}
+
+	item.fields[field] = append([]byte{}, value...)
+	item.expires = time.Time{}
+	if expire > 0 {
+		item.expires = time.Now().Add(expire)
+	}
+	s.items[key] = item
+	return nil
+}
+
+// HGetAll gets all the fields of a hash.
+func (s *MemoryStore) HGetAll(key string) (map[string][]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var fields = map[string][]byte{}
+	if item, ok := s.items[key]; ok && !item.expired(time.Now()) {
+		for field, value := range item.fields {
+			fields[field] = append([]byte{}, value...)
+		}
+	}
+	return fields, nil
+}
+
+// HDel deletes fields from a hash.
+func (s *MemoryStore) HDel(key string, fields ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[key]
+	if !ok || item.fields == nil {
+		return nil
+	}
+	for _, field := range fields {
+		delete(item.fields, field)
+	}
+	if len(item.fields) == 0 {
+		delete(s.items, key)
+	}
+	return nil
+}
+
+// Close the store, stopping its sweeper.
+func (s *MemoryStore) Close() error {
+	close(s.done)
+	return nil
+}
+
+// sweep out expired keys every so often, so they don't pile up.
+func (s *MemoryStore) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, item := range s.items {
+				if item.expired(now) {
+					delete(s.items, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+var ctx = context.Background()
+
+// ErrScriptsUnsupported is returned when running a Lua script on a Store that isn't Redis.
+var ErrScriptsUnsupported = errors.New("the cache store can't run Lua scripts")
+
+// RedisStore keeps values on a Redis server.
+type RedisStore struct {
+	Client *redis.Client
+}
+
+/*
+NewRedisStore connects to Redis.
+
+The addr format is like:
+
+- localhost:6379
+- localhost:6379/6
+
+The latter format to specify the DB number if not the default (0).
+*/
+func NewRedisStore(addr string) (*RedisStore, error) {
+	// Parse the addr string.
+	parts := strings.Split(addr, "/")
+	addr = parts[0]
+	db := 0
+	if len(parts) > 1 && len(parts[1]) > 0 {
+		a, err := strconv.Atoi(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("redis DB number was not an integer: %s", err)
+		}
+		db = a
+	}
+
+	return &RedisStore{
+		Client: redis.NewClient(&redis.Options{
+			Addr: addr,
+			DB:   db,
+		}),
+	}, nil
+}
+
+// Set a value.
+func (s *RedisStore) Set(key string, value []byte, expire time.Duration) error {
+	return s.Client.Set(ctx, key, value, expire).Err()
+}
+
+// SetNX sets a value only if the key doesn't exist.
+func (s *RedisStore) SetNX(key string, value []byte, expire time.Duration) (bool, error) {
+	return s.Client.SetNX(ctx, key, value, expire).Result()
+}
+
+// Get a value.
+func (s *RedisStore) Get(key string) ([]byte, error) {
+	val, err := s.Client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	return val, err
+}
+
+// Exists checks if a key exists.
+func (s *RedisStore) Exists(key string) (bool, error) {
+	val, err := s.Client.Exists(ctx, key).Result()
+	return val == 1, err
+}
+
+// Delete a key.
+func (s *RedisStore) Delete(key string) error {
+	return s.Client.Del(ctx, key).Err()
+}
+
+// HSet sets a field of a hash, and when the hash expires, in one transaction.
+func (s *RedisStore) HSet(key, field string, value []byte, expire time.Duration) error {
+	_, err := s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, field, value)
+		if expire > 0 {
+			pipe.PExpire(ctx, key, expire)
+		} else {
+			pipe.Persist(ctx, key)
+		}
+		return nil
+	})
+	return err
+}
+
+// HGetAll gets all the fields of a hash.
+func (s *RedisStore) HGetAll(key string) (map[string][]byte, error) {
+	val, err := s.Client.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	var fields = map[string][]byte{}
+	for field, v := range val {
+		fields[field] = []byte(v)
+	}
+	return fields, nil
+}
+
+// HDel deletes fields from a hash.
+func (s *RedisStore) HDel(key string, fields ...string) error {
+	return s.Client.HDel(ctx, key, fields...).Err()
+}
+
+// Close the connection.
+func (s *RedisStore) Close() error {
+	return s.Client.Close()
+}
+
+// Script is a Lua script run atomically on the Redis server.
+type Script struct {
+	script *redis.Script
+}
+
+// NewScript prepares a Lua script.
+func NewScript(src string) *Script {
+	return &Script{
+		script: redis.NewScript(src),
+	}
+}
+
+// Run the script with the given keys and arguments. It is sent by its SHA1 hash, and loaded
+// on the server first if it isn't cached there yet.
+//
+// Returns ErrScriptsUnsupported if the Backend isn't Redis.
+func (s *Script) Run(keys []string, args ...interface{}) (interface{}, error) {
+	store, ok := Backend.(*RedisStore)
+	if !ok {
+		return nil, ErrScriptsUnsupported
+	}
+	return s.script.Run(ctx, store.Client, keys, args...).Result()
+}
@@ -0,0 +1,194 @@
+package redis
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/log"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// SQLiteStore keeps values in an SQLite database file, for single-node deployments without a
+// Redis server. Values survive a restart.
+type SQLiteStore struct {
+	db   *gorm.DB
+	done chan struct{}
+}
+
+// CacheEntry table of the SQLite store.
+type CacheEntry struct {
+	Key       string `gorm:"primaryKey"`
+	Value     []byte
+	ExpiresAt *time.Time `gorm:"index"`
+}
+
+// CacheHashField table of the SQLite store: one field of a hash. The hash expires as a whole, so
+// all its fields have the same ExpiresAt.
+type CacheHashField struct {
+	Key       string `gorm:"primaryKey"`
+	Field     string `gorm:"primaryKey"`
+	Value     []byte
+	ExpiresAt *time.Time `gorm:"index"`
+}
+
+// NewSQLiteStore opens (or creates) the SQLite store at the filename.
+func NewSQLiteStore(filename string) (*SQLiteStore, error) {
+	db, err := gorm.Open(sqlite.Open(filename), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.AutoMigrate(&CacheEntry{}, &CacheHashField{}); err != nil {
+		return nil, err
+	}
+
+	s := &SQLiteStore{
+		db:   db,
+		done: make(chan struct{}),
+	}
+	go s.sweep()
+	return s, nil
+}
+
+// Set a value.
+func (s *SQLiteStore) Set(key string, value []byte, expire time.Duration) error {
+	var entry = CacheEntry{
+		Key:   key,
+		Value: value,
+	}
+	if expire > 0 {
+		expires := time.Now().Add(expire)
+		entry.ExpiresAt = &expires
+	}
+
+	return s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&entry).Error
+}
+
+// SetNX sets a value only if the key doesn't exist. An expired entry which hasn't been swept yet
+// is taken over, in the same statement so that only one caller can win.
+func (s *SQLiteStore) SetNX(key string, value []byte, expire time.Duration) (bool, error) {
+	var (
+		now       = time.Now()
+		expiresAt *time.Time
+	)
+	if expire > 0 {
+		expires := now.Add(expire)
+		expiresAt = &expires
+	}
+
+	result := s.db.Exec(
+		`INSERT INTO cache_entries (key, value, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at
+		WHERE cache_entries.expires_at IS NOT NULL AND cache_entries.expires_at <= ?`,
+		key, value, expiresAt, now,
+	)
+	return result.RowsAffected == 1, result.Error
+}
+
+// Get a value.
+func (s *SQLiteStore) Get(key string) ([]byte, error) {
+	var entry CacheEntry
+	err := s.db.Where("key = ? AND (expires_at IS NULL OR expires_at > ?)", key, time.Now()).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	return entry.Value, err
+}
+
+// Exists checks if a key (or hash) exists.
+func (s *SQLiteStore) Exists(key string) (bool, error) {
+	for _, model := range []interface{}{&CacheEntry{}, &CacheHashField{}} {
+		var count int64
+		err := s.db.Model(model).Where("key = ? AND (expires_at IS NULL OR expires_at > ?)", key, time.Now()).Count(&count).Error
+		if err != nil || count > 0 {
+			return count > 0, err
+		}
+	}
+	return false, nil
+}
+
+// Delete a key (or hash).
+func (s *SQLiteStore) Delete(key string) error {
+	if err := s.db.Where("key = ?", key).Delete(&CacheEntry{}).Error; err != nil {
+		return err
+	}
+	return s.db.Where("key = ?", key).Delete(&CacheHashField{}).Error
+}
+
+// HSet sets a field of a hash. The hash's other fields are given the new expiration, unless they
+// have already expired: those are left for the sweeper and not brought back.
+func (s *SQLiteStore) HSet(key, field string, value []byte, expire time.Duration) error {
+	var (
+		now   = time.Now()
+		entry = CacheHashField{
+			Key:   key,
+			Field: field,
+			Value: value,
+		}
+	)
+	if expire > 0 {
+		expires := now.Add(expire)
+		entry.ExpiresAt = &expires
+	}
+
+	if err := s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&entry).Error; err != nil {
+		return err
+	}
+	return s.db.Model(&CacheHashField{}).
+		Where("key = ? AND (expires_at IS NULL OR expires_at > ?)", key, now).
+		Update("expires_at", entry.ExpiresAt).Error
+}
+
+// HGetAll gets all the fields of a hash.
+func (s *SQLiteStore) HGetAll(key string) (map[string][]byte, error) {
+	var entries = []CacheHashField{}
+	err := s.db.Where("key = ? AND (expires_at IS NULL OR expires_at > ?)", key, time.Now()).Find(&entries).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var fields = map[string][]byte{}
+	for _, entry := range entries {
+		fields[entry.Field] = entry.Value
+	}
+	return fields, nil
+}
+
+// HDel deletes fields from a hash.
+func (s *SQLiteStore) HDel(key string, fields ...string) error {
+	return s.db.Where("key = ? AND field IN ?", key, fields).Delete(&CacheHashField{}).Error
+}
+
+// Close the database.
+func (s *SQLiteStore) Close() error {
+	close(s.done)
+	db, err := s.db.DB()
+	if err != nil {
+		return err
+	}
+	return db.Close()
+}
+
+// sweep out expired keys every so often, so they don't pile up.
+func (s *SQLiteStore) sweep() {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			for _, model := range []interface{}{&CacheEntry{}, &CacheHashField{}} {
+				if err := s.db.Where("expires_at <= ?", now).Delete(model).Error; err != nil {
+					log.Error("SQLiteStore: couldn't sweep expired keys: %s", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package redis_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/redis"
+)
+
+func TestStores(t *testing.T) {
+	sqlite, err := redis.NewSQLiteStore(filepath.Join(t.TempDir(), "cache.sqlite"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %s", err)
+	}
+
+	var stores = map[string]redis.Store{
+		"memory": redis.NewMemoryStore(),
+		"sqlite": sqlite,
+	}
+
+	for name, store := range stores {
+		if _, err := store.Get("missing"); err != redis.ErrNotFound {
+			t.Errorf("%s: Get a missing key: expected ErrNotFound but got %v", name, err)
+		}
+
+		// Set and overwrite.
+		store.Set("key", []byte("one"), 0)
+		if err := store.Set("key", []byte("two"), 0); err != nil {
+			t.Errorf("%s: Set: %s", name, err)
+		}
+		if val, err := store.Get("key"); err != nil || string(val) != "two" {
+			t.Errorf("%s: Get: expected two but got %q, %v", name, val, err)
+		}
+		if ok, err := store.Exists("key"); err != nil || !ok {
+			t.Errorf("%s: Exists: expected true but got %v, %v", name, ok, err)
+		}
+
+		// Delete.
+		if err := store.Delete("key"); err != nil {
+			t.Errorf("%s: Delete: %s", name, err)
+		}
+		if ok, _ := store.Exists("key"); ok {
+			t.Errorf("%s: key still exists after Delete", name)
+		}
+
+		// Set if absent: only the first wins, until the key expires.
+		if ok, err := store.SetNX("once", []byte("first"), 50*time.Millisecond); err != nil || !ok {
+			t.Errorf("%s: SetNX a new key: expected true but got %v, %v", name, ok, err)
+		}
+		if ok, err := store.SetNX("once", []byte("second"), time.Minute); err != nil || ok {
+			t.Errorf("%s: SetNX an existing key: expected false but got %v, %v", name, ok, err)
+		}
+		if val, _ := store.Get("once"); string(val) != "first" {
+			t.Errorf("%s: SetNX overwrote the existing key with %q", name, val)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if ok, err := store.SetNX("once", []byte("third"), time.Minute); err != nil || !ok {
+			t.Errorf("%s: SetNX an expired key: expected true but got %v, %v", name, ok, err)
+		}
+
+		// Hashes: fields are set and deleted on their own, and the last one out removes the hash.
+		store.HSet("hash", "a", []byte("one"), time.Minute)
+		store.HSet("hash", "b", []byte("two"), time.Minute)
+		if fields, err := store.HGetAll("hash"); err != nil || len(fields) != 2 || string(fields["b"]) != "two" {
+			t.Errorf("%s: HGetAll: expected fields a and b but got %q, %v", name, fields, err)
+		}
+		store.HDel("hash", "a")
+		if fields, _ := store.HGetAll("hash"); len(fields) != 1 || string(fields["b"]) != "two" {
+			t.Errorf("%s: HDel: expected field b left but got %q", name, fields)
+		}
+		store.HDel("hash", "b")
+		if ok, _ := store.Exists("hash"); ok {
+			t.Errorf("%s: hash still exists after its last field was deleted", name)
+		}
+
+		// A hash expires as a whole, and its expired fields don't come back.
+		store.HSet("hash", "a", []byte("one"), 50*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		store.HSet("hash", "b", []byte("two"), time.Minute)
+		if fields, _ := store.HGetAll("hash"); len(fields) != 1 || string(fields["b"]) != "two" {
+			t.Errorf("%s: HSet after expiry: expected only field b but got %q", name, fields)
+		}
+		store.Delete("hash")
+		if fields, _ := store.HGetAll("hash"); len(fields) != 0 {
+			t.Errorf("%s: hash still has %q after Delete", name, fields)
+		}
+
+		// Expiration.
+		store.Set("short", []byte("lived"), 50*time.Millisecond)
+		if ok, _ := store.Exists("short"); !ok {
+			t.Errorf("%s: expiring key should exist before it expires", name)
+		}
+		time.Sleep(100 * time.Millisecond)
+		if _, err := store.Get("short"); err != redis.ErrNotFound {
+			t.Errorf("%s: expected expired key to be gone but got %v", name, err)
+		}
+
+		if err := store.Close(); err != nil {
+			t.Errorf("%s: Close: %s", name, err)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	type token struct {
+		Token  string
+		UserID uint64
+	}
+
+	if err := redis.Set("token/abc", token{"abc", 42}, time.Minute); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+
+	var actual token
+	if err := redis.Get("token/abc", &actual); err != nil || actual.Token != "abc" || actual.UserID != 42 {
+		t.Errorf("Get: expected the token back but got %+v, %v", actual, err)
+	}
+
+	if _, err := redis.NewScript("return 1").Run(nil); err != redis.ErrScriptsUnsupported {
+		t.Errorf("Script.Run on the memory store: expected ErrScriptsUnsupported but got %v", err)
+	}
+}
+
+func TestUserTokens(t *testing.T) {
+	redis.SetUserToken(7, "reset/one", "one", time.Minute)
+	redis.SetUserToken(7, "reset/two", "two", 50*time.Millisecond)
+	redis.SetUserToken(8, "reset/other", "other", time.Minute)
+
+	if keys := redis.UserTokens(7); len(keys) != 2 {
+		t.Errorf("UserTokens: expected 2 keys but got %v", keys)
+	}
+
+	// Expired tokens drop out of the index.
+	time.Sleep(100 * time.Millisecond)
+	if keys := redis.UserTokens(7); len(keys) != 1 || keys[0] != "reset/one" {
+		t.Errorf("UserTokens after expiry: expected [reset/one] but got %v", keys)
+	}
+
+	if err := redis.DeleteUserTokens(7); err != nil {
+		t.Fatalf("DeleteUserTokens: %s", err)
+	}
+	if redis.Exists("reset/one") || len(redis.UserTokens(7)) > 0 {
+		t.Errorf("DeleteUserTokens: the user's tokens still exist")
+	}
+	if !redis.Exists("reset/other") {
+		t.Errorf("DeleteUserTokens: another user's token was deleted")
+	}
+}
@@ -0,0 +1,74 @@
+package redis
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+)
+
+// userTokens indexes the keys of a user's pending tokens, with when each expires. It is a Redis
+// hash, so that tokens made at the same time don't drop each other from it.
+type userTokens map[string]time.Time
+
+// userTokensKey formats the Redis key for a user's token index.
+func userTokensKey(userID uint64) string {
+	return fmt.Sprintf(config.UserTokensRedisKey, userID)
+}
+
+// getUserTokens loads a user's token index, minus the tokens which have expired.
+func getUserTokens(userID uint64) userTokens {
+	var (
+		idx = userTokens{}
+		now = time.Now()
+	)
+	if err := HGetAll(userTokensKey(userID), &idx); err != nil {
+		return userTokens{}
+	}
+
+	for key, expires := range idx {
+		if !expires.After(now) {
+			delete(idx, key)
+		}
+	}
+	return idx
+}
+
+// SetUserToken sets a token for a user (such as a password reset link) like Set, and notes its
+// key in the user's token index so that it can be cleaned up when their account is deleted.
+func SetUserToken(userID uint64, key string, v interface{}, expire time.Duration) error {
+	if err := Set(key, v, expire); err != nil {
+		return err
+	}
+
+	// The index lasts as long as the last of its tokens.
+	var longest = expire
+	for _, other := range getUserTokens(userID) {
+		if ttl := time.Until(other); ttl > longest {
+			longest = ttl
+		}
+	}
+
+	return HSet(userTokensKey(userID), key, time.Now().Add(expire), longest)
+}
+
+// UserTokens returns the keys of a user's pending tokens which still exist.
+func UserTokens(userID uint64) []string {
+	var keys = []string{}
+	for key := range getUserTokens(userID) {
+		if Exists(key) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// DeleteUserTokens deletes all of a user's pending tokens and their index.
+func DeleteUserTokens(userID uint64) error {
+	for key := range getUserTokens(userID) {
+		if err := Delete(key); err != nil {
+			return err
+		}
+	}
+	return Delete(userTokensKey(userID))
+}
@@ -0,0 +1,146 @@
+package router
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aichaos/silhouette/webapp/config"
+)
+
+// Named routes, for building their URLs.
+var (
+	names   = map[string]*Route{}
+	namesMu sync.RWMutex
+)
+
+// Name the route so its URL can be built with URLFor. Names are global to the app. Naming two
+// different paths the same panics.
+func (rt *Route) Name(name string) *Route {
+	namesMu.Lock()
+	defer namesMu.Unlock()
+
+	if existing, ok := names[name]; ok && existing.pattern != rt.pattern {
+		panic("router: route name " + name + " is already used by " + existing.pattern)
+	}
+	names[name] = rt
+	return rt
+}
+
+// HasName checks if a route of that name is registered.
+func HasName(name string) bool {
+	namesMu.RLock()
+	defer namesMu.RUnlock()
+	_, ok := names[name]
+	return ok
+}
+
+// URLFor builds the path of a named route. The params are key/value pairs: keys that name a
+// parameter of the route fill it in and any others are added as the query string, e.g.
+//
+//	URLFor("profile", "username", "alice", "tab", "photos") // "/u/alice?tab=photos"
+//
+// Values may be of any type and are formatted with fmt.Sprint.
+func URLFor(name string, params ...interface{}) (string, error) {
+	namesMu.RLock()
+	route, ok := names[name]
+	namesMu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("URLFor: no route named %q", name)
+	}
+
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("URLFor(%s): params must be key/value pairs", name)
+	}
+
+	var values = map[string]string{}
+	var query = url.Values{}
+	for i := 0; i < len(params); i += 2 {
+		key, ok := params[i].(string)
+		if !ok {
+			return "", fmt.Errorf("URLFor(%s): param key %v is not a string", name, params[i])
+		}
+		values[key] = fmt.Sprint(params[i+1])
+	}
+
+	// Fill in the path.
+	var path = []string{}
+	for _, seg := range route.segments {
+		if seg.param == "" {
+			path = append(path, seg.literal)
+			continue
+		}
+
+		value, ok := values[seg.param]
+		if !ok || (value == "" && !seg.catchAll) {
+			return "", fmt.Errorf("URLFor(%s): missing the %s parameter", name, seg.param)
+		}
+		delete(values, seg.param)
+
+		if seg.catchAll {
+			var parts = strings.Split(value, "/")
+			for i, part := range parts {
+				parts[i] = url.PathEscape(part)
+			}
+			path = append(path, strings.Join(parts, "/"))
+		} else {
+			path = append(path, url.PathEscape(value))
+		}
+	}
+
+	for key, value := range values {
+		query.Set(key, value)
+	}
+
+	var result = "/" + strings.Join(path, "/")
+	if len(query) > 0 {
+		result += "?" + query.Encode()
+	}
+	return result, nil
+}
+
+// AbsoluteURLFor builds the full URL of a named route, on the configured BaseURL, for links
+// that leave the site such as in emails.
+func AbsoluteURLFor(name string, params ...interface{}) (string, error) {
+	path, err := URLFor(name, params...)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(config.Current.BaseURL, "/") + path, nil
+}
+
+// MustURLFor is URLFor for a route name known to exist, and panics if it doesn't.
+func MustURLFor(name string, params ...interface{}) string {
+	path, err := URLFor(name, params...)
+	if err != nil {
+		panic(err)
+	}
+	return path
+}
+
+// MustAbsoluteURLFor is AbsoluteURLFor for a route name known to exist, and panics if it doesn't.
+func MustAbsoluteURLFor(name string, params ...interface{}) string {
+	path, err := AbsoluteURLFor(name, params...)
+	if err != nil {
+		panic(err)
+	}
+	return path
+}
+
+// CheckNames verifies that every name used is a registered route. The map is of route names to
+// where they were used, e.g. template filenames.
+func CheckNames(used map[string][]string) error {
+	var unknown = []string{}
+	for name, where := range used {
+		if !HasName(name) {
+			unknown = append(unknown, fmt.Sprintf("%q (in %s)", name, strings.Join(where, ", ")))
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown route names: %s", strings.Join(unknown, "; "))
+	}
+	return nil
+}
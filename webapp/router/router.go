@@ -1,54 +1,335 @@
-// Package router configures web routes.
+// Package router matches request paths to handlers, with path parameters, per-method handlers
+// and groups of routes that share middleware. The app's routes are in the routes package.
 package router
 
 import (
+	"context"
 	"net/http"
-
-	"github.com/aichaos/silhouette/webapp/config"
-	"github.com/aichaos/silhouette/webapp/controller/account"
-	"github.com/aichaos/silhouette/webapp/controller/admin"
-	"github.com/aichaos/silhouette/webapp/controller/api"
-	"github.com/aichaos/silhouette/webapp/controller/index"
-	"github.com/aichaos/silhouette/webapp/middleware"
+	"sort"
+	"strings"
 )
 
-func New() http.Handler {
-	mux := http.NewServeMux()
-
-	// Register controller endpoints.
-	mux.HandleFunc("/", index.Create())
-	mux.HandleFunc("/favicon.ico", index.Favicon())
-	mux.HandleFunc("/about", index.StaticTemplate("about.html")())
-	mux.HandleFunc("/login", account.Login())
-	mux.HandleFunc("/logout", account.Logout())
-	mux.HandleFunc("/signup", account.Signup())
-	mux.HandleFunc("/forgot-password", account.ForgotPassword())
-	mux.HandleFunc("/settings/confirm-email", account.ConfirmEmailChange())
-
-	// Login Required. Pages that non-certified users can access.
-	mux.Handle("/me", middleware.LoginRequired(account.Dashboard()))
-	mux.Handle("/settings", middleware.LoginRequired(account.Settings()))
-	mux.Handle("/account/delete", middleware.LoginRequired(account.Delete()))
-
-	// Certification Required. Pages that only full (verified) members can access.
-	mux.Handle("/members", middleware.LoginRequired(account.Search()))
-
-	// Admin endpoints.
-	mux.Handle("/admin", middleware.AdminRequired(admin.Dashboard()))
-	mux.Handle("/admin/user-action", middleware.AdminRequired(admin.UserActions()))
-
-	// JSON API endpoints.
-	mux.HandleFunc("/v1/version", api.Version())
-	mux.HandleFunc("/v1/users/me", api.LoginOK())
-	mux.HandleFunc("/v1/echo", api.Echo())
-
-	// Static files.
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir(config.StaticPath))))
-
-	// Global middlewares.
-	withCSRF := middleware.CSRF(mux)
-	withSession := middleware.Session(withCSRF)
-	withRecovery := middleware.Recovery(withSession)
-	withLogger := middleware.Logging(withRecovery)
-	return withLogger
+// Middleware wraps a handler, as for a group of routes.
+type Middleware func(http.Handler) http.Handler
+
+// Router is an http.Handler that dispatches to the registered routes.
+//
+// Patterns are paths whose segments may be parameters: "/u/{username}" matches "/u/alice", and
+// the handler reads it with Param(r, "username"). A final "{name...}" parameter matches the rest
+// of the path, e.g. "/static/{path...}". Where more than one pattern matches, the one with
+// literal segments earliest wins, so "/v1/users/me" is preferred over "/v1/users/{username}".
+type Router struct {
+	// Called when no route matches the path. Defaults to http.NotFound.
+	NotFound http.Handler
+
+	// Called when routes match the path but not the method. The Allow header is already set.
+	// Defaults to a plain text 405 error.
+	MethodNotAllowed http.Handler
+
+	table      *table
+	prefix     string
+	middleware []Middleware
+}
+
+// table of routes shared by a router and its groups.
+type table struct {
+	routes []*Route
+}
+
+// Route is a registered route, which may be given a name with Name.
+type Route struct {
+	method   string // blank for any method
+	pattern  string
+	segments []segment
+	handler  http.Handler
+}
+
+type segment struct {
+	literal  string
+	param    string // name, if this segment is a parameter
+	catchAll bool   // a {name...} parameter matching the rest of the path
+}
+
+// Match priority of a segment: literals beat parameters, which beat catch-alls.
+func (s segment) rank() int {
+	switch {
+	case s.catchAll:
+		return 0
+	case s.param != "":
+		return 1
+	}
+	return 2
+}
+
+// New creates an empty router.
+func New() *Router {
+	return &Router{
+		table: &table{},
+	}
+}
+
+// Group returns a router for routes under a path prefix that run through the middleware, in
+// addition to any of this router's. The first middleware given runs first. Routes added to the
+// group are served by the router it came from.
+func (rt *Router) Group(prefix string, middleware ...Middleware) *Router {
+	return &Router{
+		table:      rt.table,
+		prefix:     rt.prefix + prefix,
+		middleware: append(append([]Middleware{}, rt.middleware...), middleware...),
+	}
+}
+
+// Handle registers a handler for a method ("GET", "POST", ...) and path pattern. A blank method
+// matches any method. Registering a malformed or duplicate route panics.
+func (rt *Router) Handle(method, pattern string, handler http.Handler) *Route {
+	pattern = rt.prefix + pattern
+	segments, err := parsePattern(pattern)
+	if err != nil {
+		panic(err)
+	}
+
+	for _, existing := range rt.table.routes {
+		if existing.method == method && samePattern(existing.segments, segments) {
+			panic("router: route " + method + " " + pattern + " conflicts with " + existing.pattern)
+		}
+	}
+
+	// Wrap it in the group's middleware, the first given outermost.
+	for i := len(rt.middleware) - 1; i >= 0; i-- {
+		handler = rt.middleware[i](handler)
+	}
+
+	route := &Route{
+		method:   method,
+		pattern:  pattern,
+		segments: segments,
+		handler:  handler,
+	}
+	rt.table.routes = append(rt.table.routes, route)
+	return route
+}
+
+// Get registers a handler for GET (and HEAD) requests.
+func (rt *Router) Get(pattern string, handler http.Handler) *Route {
+	return rt.Handle(http.MethodGet, pattern, handler)
+}
+
+// Post registers a handler for POST requests.
+func (rt *Router) Post(pattern string, handler http.Handler) *Route {
+	return rt.Handle(http.MethodPost, pattern, handler)
+}
+
+// GetPost registers a handler for both GET and POST requests, as for a page with a form that
+// posts back to it. Naming the returned route names the path for both.
+func (rt *Router) GetPost(pattern string, handler http.Handler) *Route {
+	rt.Post(pattern, handler)
+	return rt.Get(pattern, handler)
+}
+
+// Any registers a handler for every method.
+func (rt *Router) Any(pattern string, handler http.Handler) *Route {
+	return rt.Handle("", pattern, handler)
+}
+
+// ServeHTTP dispatches the request to the best matching route.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		path    = splitPath(r.URL.Path)
+		best    *Route
+		params  map[string]string
+		allowed = map[string]bool{}
+	)
+
+	for _, candidate := range rt.table.routes {
+		found, ok := candidate.match(path)
+		if !ok {
+			continue
+		}
+
+		if !candidate.allows(r.Method) {
+			if candidate.method == "" {
+				continue
+			}
+			allowed[candidate.method] = true
+			if candidate.method == http.MethodGet {
+				allowed[http.MethodHead] = true
+			}
+			continue
+		}
+
+		if best == nil || morePrecise(candidate.segments, best.segments) {
+			best, params = candidate, found
+		}
+	}
+
+	if best != nil {
+		if len(params) > 0 {
+			r = r.WithContext(context.WithValue(r.Context(), paramsKey, params))
+		}
+		best.handler.ServeHTTP(w, r)
+		return
+	}
+
+	// The path exists, but not for this method?
+	if len(allowed) > 0 {
+		var methods = []string{}
+		for method := range allowed {
+			methods = append(methods, method)
+		}
+		sort.Strings(methods)
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+
+		if rt.MethodNotAllowed != nil {
+			rt.MethodNotAllowed.ServeHTTP(w, r)
+		} else {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	if rt.NotFound != nil {
+		rt.NotFound.ServeHTTP(w, r)
+	} else {
+		http.NotFound(w, r)
+	}
+}
+
+// allows checks the route takes the method. GET routes also answer HEAD requests.
+func (rt *Route) allows(method string) bool {
+	return rt.method == "" || rt.method == method || (rt.method == http.MethodGet && method == http.MethodHead)
+}
+
+// match the route against a split request path, returning its parameters.
+func (rt *Route) match(path []string) (map[string]string, bool) {
+	var params map[string]string
+	for i, seg := range rt.segments {
+		if seg.catchAll {
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[seg.param] = strings.Join(path[i:], "/")
+			return params, true
+		}
+
+		if i >= len(path) {
+			return nil, false
+		}
+
+		if seg.param != "" {
+			if path[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = map[string]string{}
+			}
+			params[seg.param] = path[i]
+		} else if seg.literal != path[i] {
+			return nil, false
+		}
+	}
+
+	if len(path) != len(rt.segments) {
+		return nil, false
+	}
+	return params, true
+}
+
+// morePrecise compares two patterns that match the same path: the first to have a literal
+// segment where the other has a parameter wins.
+func morePrecise(a, b []segment) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i].rank() != b[i].rank() {
+			return a[i].rank() > b[i].rank()
+		}
+	}
+	return len(a) > len(b)
+}
+
+// samePattern checks if two patterns match the same paths.
+func samePattern(a, b []segment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].literal != b[i].literal || (a[i].param == "") != (b[i].param == "") || a[i].catchAll != b[i].catchAll {
+			return false
+		}
+	}
+	return true
+}
+
+// splitPath splits a URL path into its segments. A trailing slash is not significant.
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+// parsePattern parses a route pattern into segments.
+func parsePattern(pattern string) ([]segment, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return nil, &PatternError{pattern, "must begin with a /"}
+	}
+
+	var (
+		parts    = splitPath(pattern)
+		segments = make([]segment, 0, len(parts))
+		names    = map[string]bool{}
+	)
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") || !strings.HasSuffix(part, "}") {
+			if strings.ContainsAny(part, "{}") {
+				return nil, &PatternError{pattern, "a parameter must be a whole path segment"}
+			}
+			segments = append(segments, segment{literal: part})
+			continue
+		}
+
+		var (
+			name     = strings.TrimSuffix(strings.TrimPrefix(part, "{"), "}")
+			catchAll = strings.HasSuffix(name, "...")
+		)
+		name = strings.TrimSuffix(name, "...")
+		if name == "" {
+			return nil, &PatternError{pattern, "a parameter needs a name"}
+		}
+		if names[name] {
+			return nil, &PatternError{pattern, "parameter {" + name + "} is used twice"}
+		}
+		if catchAll && i != len(parts)-1 {
+			return nil, &PatternError{pattern, "{" + name + "...} must be the last segment"}
+		}
+		names[name] = true
+
+		segments = append(segments, segment{
+			param:    name,
+			catchAll: catchAll,
+		})
+	}
+
+	return segments, nil
+}
+
+// PatternError is a malformed route pattern.
+type PatternError struct {
+	Pattern string
+	Message string
+}
+
+func (e *PatternError) Error() string {
+	return "router: pattern " + e.Pattern + ": " + e.Message
+}
+
+type contextKey string
+
+const paramsKey = contextKey("params")
+
+// Param returns the value of a path parameter of the route that matched the request, or blank.
+func Param(r *http.Request, name string) string {
+	if params, ok := r.Context().Value(paramsKey).(map[string]string); ok {
+		return params[name]
+	}
+	return ""
 }
@@ -0,0 +1,199 @@
+package router_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aichaos/silhouette/webapp/router"
+)
+
+// echo responds with a name and the request's path parameters.
+func echo(name string, params ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var values = []string{name}
+		for _, param := range params {
+			values = append(values, param+"="+router.Param(r, param))
+		}
+		fmt.Fprint(w, strings.Join(values, " "))
+	})
+}
+
+// tag is middleware that adds its name to a response header.
+func tag(name string) router.Middleware {
+	return func(handler http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Middleware", name)
+			handler.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := router.New()
+	r.Get("/", echo("index"))
+	r.GetPost("/login", echo("login"))
+	r.Get("/u/{username}", echo("profile", "username"))
+	r.Get("/u/{username}/photos/{id}", echo("photo", "username", "id"))
+	r.Get("/static/{path...}", echo("static", "path"))
+	r.Any("/anything", echo("anything"))
+
+	api := r.Group("/v1", tag("api"))
+	api.Get("/users/me", echo("me"))
+	api.Get("/users/{username}", echo("user", "username"))
+	api.Post("/echo", echo("echo"))
+
+	admin := r.Group("/admin", tag("auth"))
+	admin.Get("/", echo("admin"))
+	admin.Group("", tag("admin")).Get("/users", echo("admin users"))
+
+	var tests = []struct {
+		Method     string
+		Path       string
+		Status     int
+		Body       string
+		Allow      string
+		Middleware []string
+	}{
+		{"GET", "/", 200, "index", "", nil},
+		{"HEAD", "/", 200, "", "", nil},
+		{"POST", "/", 405, "", "GET, HEAD", nil},
+		{"GET", "/login", 200, "login", "", nil},
+		{"POST", "/login", 200, "login", "", nil},
+		{"DELETE", "/login", 405, "", "GET, HEAD, POST", nil},
+		{"GET", "/u/alice", 200, "profile username=alice", "", nil},
+		{"GET", "/u/alice/", 200, "profile username=alice", "", nil},
+		{"GET", "/u/", 404, "", "", nil},
+		{"GET", "/u/alice/photos/5", 200, "photo username=alice id=5", "", nil},
+		{"GET", "/u/alice/photos", 404, "", "", nil},
+		{"GET", "/static/css/theme.css", 200, "static path=css/theme.css", "", nil},
+		{"PUT", "/anything", 200, "anything", "", nil},
+		{"GET", "/v1/users/me", 200, "me", "", []string{"api"}},
+		{"GET", "/v1/users/bob", 200, "user username=bob", "", []string{"api"}},
+		{"GET", "/v1/echo", 405, "", "POST", nil},
+		{"GET", "/admin", 200, "admin", "", []string{"auth"}},
+		{"GET", "/admin/users", 200, "admin users", "", []string{"auth", "admin"}},
+		{"GET", "/nowhere", 404, "", "", nil},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(test.Method, test.Path, nil))
+
+		if w.Code != test.Status {
+			t.Errorf("%s %s: expected status %d but got %d", test.Method, test.Path, test.Status, w.Code)
+			continue
+		}
+		if test.Body != "" && w.Body.String() != test.Body {
+			t.Errorf("%s %s: expected body %q but got %q", test.Method, test.Path, test.Body, w.Body.String())
+		}
+		if allow := w.Header().Get("Allow"); allow != test.Allow {
+			t.Errorf("%s %s: expected Allow %q but got %q", test.Method, test.Path, test.Allow, allow)
+		}
+		if mw := w.Header().Values("X-Middleware"); strings.Join(mw, ",") != strings.Join(test.Middleware, ",") {
+			t.Errorf("%s %s: expected middleware %v but got %v", test.Method, test.Path, test.Middleware, mw)
+		}
+	}
+}
+
+func TestErrorHandlers(t *testing.T) {
+	r := router.New()
+	r.Post("/form", echo("form"))
+	r.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, "custom 404")
+	})
+	r.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprint(w, "custom 405")
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/missing", nil))
+	if w.Code != 404 || w.Body.String() != "custom 404" {
+		t.Errorf("expected the custom 404 handler but got %d %q", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/form", nil))
+	if w.Code != 405 || w.Body.String() != "custom 405" || w.Header().Get("Allow") != "POST" {
+		t.Errorf("expected the custom 405 handler but got %d %q (Allow: %q)", w.Code, w.Body.String(), w.Header().Get("Allow"))
+	}
+}
+
+func TestBadPatterns(t *testing.T) {
+	for _, pattern := range []string{
+		"no-slash",
+		"/u/{}",
+		"/u/x{name}",
+		"/u/{name}/{name}",
+		"/static/{path...}/more",
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("pattern %q: expected a panic", pattern)
+				}
+			}()
+			router.New().Get(pattern, echo("x"))
+		}()
+	}
+
+	// Duplicate routes panic, even with differently named parameters.
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected a duplicate route to panic")
+		}
+	}()
+	r := router.New()
+	r.Get("/u/{username}", echo("a"))
+	r.Get("/u/{name}", echo("b"))
+}
+
+func TestURLFor(t *testing.T) {
+	r := router.New()
+	r.Get("/test/u/{username}", echo("profile")).Name("test.profile")
+	r.GetPost("/test/admin/user", echo("user")).Name("test.admin.user")
+	r.Get("/test/static/{path...}", echo("static")).Name("test.static")
+
+	var tests = []struct {
+		Name   string
+		Params []interface{}
+		Expect string
+	}{
+		{"test.profile", []interface{}{"username", "alice"}, "/test/u/alice"},
+		{"test.profile", []interface{}{"username", "a b/c"}, "/test/u/a%20b%2Fc"},
+		{"test.profile", []interface{}{"username", "alice", "tab", "photos", "page", 2}, "/test/u/alice?page=2&tab=photos"},
+		{"test.admin.user", []interface{}{"user_id", uint64(42)}, "/test/admin/user?user_id=42"},
+		{"test.static", []interface{}{"path", "css/theme.css"}, "/test/static/css/theme.css"},
+	}
+	for _, test := range tests {
+		actual, err := router.URLFor(test.Name, test.Params...)
+		if err != nil || actual != test.Expect {
+			t.Errorf("URLFor(%s, %v): expected %q but got %q, %v", test.Name, test.Params, test.Expect, actual, err)
+		}
+	}
+
+	// Errors.
+	for _, params := range [][]interface{}{
+		{},                              // missing username
+		{"username", ""},                // blank username
+		{"username"},                    // odd number of params
+		{1, "alice", "username", "bob"}, // key not a string
+	} {
+		if actual, err := router.URLFor("test.profile", params...); err == nil {
+			t.Errorf("URLFor(test.profile, %v): expected an error but got %q", params, actual)
+		}
+	}
+	if _, err := router.URLFor("test.nonexistent"); err == nil {
+		t.Errorf("URLFor(test.nonexistent): expected an error")
+	}
+
+	if err := router.CheckNames(map[string][]string{"test.profile": {"a.html"}}); err != nil {
+		t.Errorf("CheckNames: unexpected error %s", err)
+	}
+	if err := router.CheckNames(map[string][]string{"test.nonexistent": {"a.html"}}); err == nil {
+		t.Errorf("CheckNames: expected an error for an unknown name")
+	}
+}
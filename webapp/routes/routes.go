@@ -0,0 +1,106 @@
+// Package routes configures the web routes.
+package routes
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/controller/account"
+	"github.com/aichaos/silhouette/webapp/controller/admin"
+	"github.com/aichaos/silhouette/webapp/controller/api"
+	"github.com/aichaos/silhouette/webapp/controller/index"
+	"github.com/aichaos/silhouette/webapp/middleware"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/router"
+	"github.com/aichaos/silhouette/webapp/templates"
+)
+
+func New() http.Handler {
+	// Routes are named for router.URLFor and the URLFor template function.
+	r := router.New()
+	r.NotFound = errorPage(templates.NotFoundPage, http.StatusNotFound, "Not Found")
+	r.MethodNotAllowed = errorPage(templates.MethodNotAllowedPage, http.StatusMethodNotAllowed, "Method Not Allowed")
+
+	// Register controller endpoints.
+	r.Get("/", index.Create()).Name("index")
+	r.Get("/favicon.ico", index.Favicon())
+	r.Get("/about", index.StaticTemplate("about.html")()).Name("about")
+	r.GetPost("/login", middleware.RateLimit("login", account.Login())).Name("login")
+	r.GetPost("/login/2fa", middleware.RateLimit("login", account.LoginTwoFactor())).Name("login.2fa")
+	r.GetPost("/login/magic", middleware.RateLimit("login", account.MagicLink())).Name("login.magic")
+	r.Get("/logout", account.Logout()).Name("logout")
+	r.GetPost("/signup", middleware.RateLimit("signup", middleware.ProofOfWork(account.Signup()))).Name("signup")
+	r.GetPost("/forgot-password", middleware.RateLimit("forgot-password", middleware.ProofOfWork(account.ForgotPassword()))).Name("forgot_password")
+	r.GetPost("/auth/oidc/login", account.OIDCLogin()).Name("oidc.login")
+	r.Get("/auth/oidc/callback", account.OIDCCallback()).Name("oidc.callback")
+	r.GetPost("/auth/oidc/signup", middleware.RateLimit("signup", account.OIDCSignup())).Name("oidc.signup")
+	r.Get("/settings/confirm-email", account.ConfirmEmailChange()).Name("settings.confirm_email")
+	r.GetPost("/account/reactivate", account.Reactivate()).Name("account.reactivate")
+	r.Get("/account/cancel-deletion", account.CancelDeletion()).Name("account.cancel_deletion")
+	r.Get("/u/{username}", account.Profile()).Name("profile")
+
+	// Login Required. Pages that non-certified users can access.
+	loggedIn := r.Group("", middleware.LoginRequired)
+	loggedIn.Get("/me", account.Dashboard()).Name("dashboard")
+	loggedIn.Post("/admin/unimpersonate", admin.StopImpersonating()).Name("admin.unimpersonate")
+
+	// Pages an impersonating admin may not use.
+	self := loggedIn.Group("", middleware.NoImpersonation)
+	self.GetPost("/settings", account.Settings()).Name("settings")
+	self.GetPost("/settings/sessions", account.Sessions()).Name("settings.sessions")
+	self.GetPost("/settings/api-tokens", account.APITokens()).Name("settings.api_tokens")
+	self.GetPost("/settings/invites", account.Invites()).Name("settings.invites")
+	self.GetPost("/settings/export", account.Export()).Name("settings.export")
+	self.GetPost("/account/delete", account.Delete()).Name("account.delete")
+
+	// Certification Required. Pages that only full (verified) members can access.
+	loggedIn.Get("/members", account.Search()).Name("members")
+
+	// Admin endpoints.
+	adminOnly := r.Group("/admin", middleware.AdminRequired)
+	adminOnly.Get("/", admin.Dashboard()).Name("admin")
+	adminOnly.GetPost("/user-action", admin.UserActions()).Name("admin.user_action") // checks per intent
+	adminOnly.GetPost("/users", middleware.PermissionRequired(models.PermissionUsersView, admin.Users())).Name("admin.users")
+	adminOnly.Get("/user", middleware.PermissionRequired(models.PermissionUsersView, admin.UserDetail())).Name("admin.user")
+	adminOnly.Get("/audit", middleware.PermissionRequired(models.PermissionAuditView, admin.AuditLog())).Name("admin.audit")
+	adminOnly.GetPost("/approvals", middleware.PermissionRequired(models.PermissionUsersApprove, admin.Approvals())).Name("admin.approvals")
+	adminOnly.GetPost("/deletions", middleware.PermissionRequired(models.PermissionUsersDelete, admin.Deletions())).Name("admin.deletions")
+	adminOnly.GetPost("/invites", middleware.PermissionRequired(models.PermissionUsersInvite, admin.Invites())).Name("admin.invites")
+
+	// JSON API endpoints.
+	// These accept a personal API token (Authorization: Bearer) or the session cookie.
+	v1 := r.Group("/v1", middleware.APIAuth, rateLimit("api"))
+	v1.Get("/version", api.Version()).Name("api.version")
+	v1.Get("/users/me", middleware.APIScopeRequired(models.APIScopeAccountRead, api.LoginOK())).Name("api.users.me")
+	v1.Get("/users/{username}", middleware.APIScopeRequired(models.APIScopeUsersRead, api.UserProfile())).Name("api.users.profile")
+	v1.Post("/echo", api.Echo()).Name("api.echo")
+
+	// Static files.
+	r.Get("/static/{path...}", http.StripPrefix("/static/", http.FileServer(http.Dir(config.StaticPath)))).Name("static")
+
+	// Global middlewares.
+	withCSRF := middleware.CSRF(r)
+	withSession := middleware.Session(withCSRF)
+	withRecovery := middleware.Recovery(withSession)
+	withLogger := middleware.Logging(withRecovery)
+	return withLogger
+}
+
+// rateLimit adapts middleware.RateLimit for a route group.
+func rateLimit(name string) router.Middleware {
+	return func(handler http.Handler) http.Handler {
+		return middleware.RateLimit(name, handler)
+	}
+}
+
+// errorPage serves an HTML error page, or a JSON error for the API.
+func errorPage(page http.Handler, statusCode int, message string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v1/") {
+			middleware.SendJSONError(w, statusCode, message)
+			return
+		}
+		page.ServeHTTP(w, r)
+	})
+}
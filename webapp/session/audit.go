@@ -0,0 +1,55 @@
+package session
+
+import (
+	"net/http"
+
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/models"
+)
+
+// Audit records an event on the target user's account in the audit log, filling in the actor and
+// IP address from the request. Failing to write the entry is logged but doesn't stop the action.
+func Audit(r *http.Request, target *models.User, entry models.AuditLog) {
+	sess := Get(r)
+	if sess == nil {
+		sess = New()
+		sess.IPAddress = RemoteAddr(r)
+	}
+	sess.audit(target, entry)
+}
+
+// audit records an event for the session's user. An impersonating admin is the actor for
+// anything done on the session; with nobody logged in (such as following a link from an email)
+// the target is taken to have acted on their own account.
+func (s *Session) audit(target *models.User, entry models.AuditLog) {
+	if entry.ActorID == 0 {
+		if s.Impersonator > 0 {
+			entry.ActorID = s.Impersonator
+		} else if s.LoggedIn {
+			entry.ActorID = s.UserID
+		} else if target != nil {
+			entry.ActorID = target.ID
+		}
+	}
+
+	if target != nil {
+		entry.TargetID = target.ID
+		entry.TargetUsername = target.Username
+	}
+
+	if entry.ActorUsername == "" && entry.ActorID > 0 {
+		if target != nil && entry.ActorID == target.ID {
+			entry.ActorUsername = target.Username
+		} else if actor, err := models.GetUser(entry.ActorID); err == nil {
+			entry.ActorUsername = actor.Username
+		}
+	}
+
+	if entry.IPAddress == "" {
+		entry.IPAddress = s.IPAddress
+	}
+
+	if err := models.CreateAuditLog(&entry); err != nil {
+		log.Error("Audit: couldn't record %s by user %d on user %d: %s", entry.Action, entry.ActorID, entry.TargetID, err)
+	}
+}
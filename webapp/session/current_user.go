@@ -7,19 +7,25 @@ import (
 	"github.com/aichaos/silhouette/webapp/models"
 )
 
-// CurrentUser returns the current logged in user via session cookie.
+// CurrentUser returns the current logged in user via session cookie or API token.
 func CurrentUser(r *http.Request) (*models.User, error) {
+	// Did we already get the CurrentUser once before? (Or from an API token.)
+	ctx := r.Context()
+	if user, ok := ctx.Value(CurrentUserKey).(*models.User); ok {
+		return user, nil
+	}
+
 	sess := Get(r)
 	if sess.LoggedIn {
-		// Did we already get the CurrentUser once before?
-		ctx := r.Context()
-		if user, ok := ctx.Value(CurrentUserKey).(*models.User); ok {
-			return user, nil
-		}
-
 		// Load the associated user ID.
 		return models.GetUser(sess.UserID)
 	}
 
 	return nil, errors.New("request session is not logged in")
 }
+
+// APIToken returns the API token that authenticated the request, if any.
+func APIToken(r *http.Request) (*models.APIToken, bool) {
+	token, ok := r.Context().Value(APITokenKey).(*models.APIToken)
+	return token, ok
+}
@@ -0,0 +1,20 @@
+package session
+
+import (
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/models/deletion"
+)
+
+// Sign deleted users out everywhere.
+func init() {
+	deletion.RegisterCache(deletion.CacheStep{
+		Name: "Sessions",
+		Count: func(user *models.User) (int64, error) {
+			sessions, err := ListSessions(user.ID)
+			return int64(len(sessions)), err
+		},
+		Delete: func(user *models.User) error {
+			return RevokeAllSessions(user.ID, "")
+		},
+	})
+}
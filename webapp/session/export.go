@@ -0,0 +1,37 @@
+package session
+
+import (
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/models/export"
+)
+
+// Where the user is logged in, for their data export; but not the session IDs, which are the
+// cookies' secret values.
+func init() {
+	type session struct {
+		IPAddress string
+		UserAgent string
+		CreatedAt time.Time
+		LastSeen  time.Time
+	}
+
+	export.Register(export.Exporter{Name: "sessions", Export: func(user *models.User) (interface{}, error) {
+		sessions, err := ListSessions(user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		var result = []session{}
+		for _, s := range sessions {
+			result = append(result, session{
+				IPAddress: s.IPAddress,
+				UserAgent: s.UserAgent,
+				CreatedAt: s.CreatedAt,
+				LastSeen:  s.LastSeen,
+			})
+		}
+		return result, nil
+	}})
+}
@@ -0,0 +1,140 @@
+package session
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/redis"
+)
+
+// Info about one of a user's logged-in sessions, kept in the per-user session index in Redis.
+type Info struct {
+	UUID      string    `json:"uuid"`
+	IPAddress string    `json:"ipAddress"`
+	UserAgent string    `json:"userAgent"`
+	CreatedAt time.Time `json:"createdAt"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// Index of a user's sessions, keyed by session UUID. It is a Redis hash, so that each session
+// is added and removed on its own: concurrent logins and sign outs can't lose each other's
+// changes.
+type Index map[string]Info
+
+// indexKey formats the Redis key for a user's session index.
+func indexKey(userID uint64) string {
+	return fmt.Sprintf(config.SessionIndexRedisKey, userID)
+}
+
+// getIndex loads a user's session index from Redis (empty if not found).
+func getIndex(userID uint64) Index {
+	var idx = Index{}
+	if err := redis.HGetAll(indexKey(userID), &idx); err != nil {
+		log.Error("session.getIndex: couldn't load session index for user %d: %s", userID, err)
+		return Index{}
+	}
+	return idx
+}
+
+// index records (or refreshes) this session in its user's session index.
+func (s *Session) index() {
+	// Impersonated sessions belong to the admin, not the user.
+	if !s.LoggedIn || s.UserID == 0 || s.Impersonator > 0 {
+		return
+	}
+
+	info, ok := getIndex(s.UserID)[s.UUID]
+	if !ok {
+		info = Info{
+			UUID:      s.UUID,
+			CreatedAt: time.Now(),
+		}
+	}
+
+	// Keep the last known IP and user agent if this save didn't come from a request.
+	if s.IPAddress != "" {
+		info.IPAddress = s.IPAddress
+	}
+	if s.UserAgent != "" {
+		info.UserAgent = s.UserAgent
+	}
+	info.LastSeen = s.LastSeen
+
+	if err := redis.HSet(indexKey(s.UserID), s.UUID, info, config.SessionCookieMaxAge*time.Second); err != nil {
+		log.Error("Session.index: couldn't save session index for user %d: %s", s.UserID, err)
+	}
+}
+
+// unindex removes a session UUID from its user's session index.
+func unindex(userID uint64, uuid string) {
+	if userID == 0 {
+		return
+	}
+
+	if err := redis.HDel(indexKey(userID), uuid); err != nil {
+		log.Error("session.unindex: couldn't update session index for user %d: %s", userID, err)
+	}
+}
+
+// ListSessions returns a user's active sessions, most recently seen first. Sessions which
+// have expired out of Redis are pruned from the index.
+func ListSessions(userID uint64) ([]Info, error) {
+	var (
+		result = []Info{}
+		pruned = []string{}
+	)
+
+	for uuid, info := range getIndex(userID) {
+		if !redis.Exists(fmt.Sprintf(config.SessionRedisKeyFormat, uuid)) {
+			pruned = append(pruned, uuid)
+			continue
+		}
+		result = append(result, info)
+	}
+
+	if err := redis.HDel(indexKey(userID), pruned...); err != nil {
+		return result, err
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastSeen.After(result[j].LastSeen)
+	})
+
+	return result, nil
+}
+
+// RevokeSession logs out one of a user's sessions by deleting it from Redis.
+func RevokeSession(userID uint64, uuid string) error {
+	if _, ok := getIndex(userID)[uuid]; !ok {
+		return fmt.Errorf("session not found")
+	}
+
+	if err := redis.Delete(fmt.Sprintf(config.SessionRedisKeyFormat, uuid)); err != nil {
+		return err
+	}
+
+	return redis.HDel(indexKey(userID), uuid)
+}
+
+// RevokeAllSessions logs out all of a user's sessions, except for the one UUID given (which
+// may be blank to log out everywhere).
+func RevokeAllSessions(userID uint64, except string) error {
+	var revoked = []string{}
+
+	for uuid := range getIndex(userID) {
+		if uuid == except {
+			continue
+		}
+
+		if err := redis.Delete(fmt.Sprintf(config.SessionRedisKeyFormat, uuid)); err != nil {
+			return err
+		}
+		revoked = append(revoked, uuid)
+	}
+
+	log.Info("RevokeAllSessions: logged out %d session(s) for user %d", len(revoked), userID)
+	return redis.HDel(indexKey(userID), revoked...)
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/aichaos/silhouette/webapp/mail"
 	"github.com/aichaos/silhouette/webapp/models"
 	"github.com/aichaos/silhouette/webapp/redis"
+	"github.com/aichaos/silhouette/webapp/router"
 )
 
 // Session cookie object that is kept server side in Redis.
@@ -26,12 +27,24 @@ type Session struct {
 	Errors       []string  `json:"errors,omitempty"`
 	Impersonator uint64    `json:"impersonator,omitempty"`
 	LastSeen     time.Time `json:"lastSeen"`
+
+	// When an admin's impersonation of the user ends automatically.
+	ImpersonateExpires time.Time `json:"impersonateExpires,omitempty"`
+
+	// Password was verified but a two-factor code is still needed to log in.
+	TwoFactorUserID  uint64    `json:"twoFactorUserId,omitempty"`
+	TwoFactorExpires time.Time `json:"twoFactorExpires,omitempty"`
+
+	// Client details from the current request, for the user's session index.
+	IPAddress string `json:"-"`
+	UserAgent string `json:"-"`
 }
 
 const (
 	ContextKey     = "session"
 	CurrentUserKey = "current_user"
 	CSRFKey        = "csrf"
+	APITokenKey    = "api_token" // *models.APIToken when authenticated by Bearer token
 )
 
 // New creates a blank session object.
@@ -46,6 +59,8 @@ func New() *Session {
 // Load the session from the browser session_id token and Redis or creates a new session.
 func LoadOrNew(r *http.Request) *Session {
 	var sess = New()
+	sess.IPAddress = RemoteAddr(r)
+	sess.UserAgent = r.UserAgent()
 
 	// Read the session cookie value.
 	cookie, err := r.Cookie(config.SessionCookieName)
@@ -89,6 +104,9 @@ func (s *Session) Save(w http.ResponseWriter) {
 		log.Error("Session.Save: couldn't write to Redis: %s", err)
 	}
 
+	// Keep the user's session index up to date.
+	s.index()
+
 	cookie := &http.Cookie{
 		Name:     config.SessionCookieName,
 		Value:    s.UUID,
@@ -165,9 +183,18 @@ func LoginUser(w http.ResponseWriter, r *http.Request, u *models.User) error {
 	}
 
 	sess := Get(r)
+
+	// Switching accounts on this session? Take it out of the old user's index.
+	if sess.UserID != u.ID || sess.Impersonator > 0 {
+		unindex(sess.UserID, sess.UUID)
+	}
+
 	sess.LoggedIn = true
 	sess.UserID = u.ID
 	sess.Impersonator = 0
+	sess.ImpersonateExpires = time.Time{}
+	sess.TwoFactorUserID = 0
+	sess.TwoFactorExpires = time.Time{}
 	sess.Save(w)
 
 	// Ping the user's last login time.
@@ -175,21 +202,67 @@ func LoginUser(w http.ResponseWriter, r *http.Request, u *models.User) error {
 	return u.Save()
 }
 
+// BeginTwoFactor records that the user has entered the correct password but must still provide
+// their two-factor code before the session is logged in.
+func BeginTwoFactor(w http.ResponseWriter, r *http.Request, u *models.User) error {
+	if u == nil || u.ID == 0 {
+		return errors.New("not a valid user account")
+	}
+
+	sess := Get(r)
+	sess.TwoFactorUserID = u.ID
+	sess.TwoFactorExpires = time.Now().Add(config.TwoFactorLoginExpires)
+	sess.Save(w)
+	return nil
+}
+
+// PendingTwoFactor returns the user who is awaiting a two-factor code on this session.
+func PendingTwoFactor(r *http.Request) (*models.User, error) {
+	sess := Get(r)
+	if sess == nil || sess.TwoFactorUserID == 0 {
+		return nil, errors.New("no two-factor login is in progress")
+	}
+
+	if time.Now().After(sess.TwoFactorExpires) {
+		return nil, errors.New("the two-factor login has expired")
+	}
+
+	return models.GetUser(sess.TwoFactorUserID)
+}
+
+// CancelTwoFactor clears a pending two-factor login from the session.
+func CancelTwoFactor(w http.ResponseWriter, r *http.Request) {
+	sess := Get(r)
+	sess.TwoFactorUserID = 0
+	sess.TwoFactorExpires = time.Time{}
+	sess.Save(w)
+}
+
 // ImpersonateUser assumes the role of the user impersonated by an admin uid.
 func ImpersonateUser(w http.ResponseWriter, r *http.Request, u *models.User, impersonator *models.User, reason string) error {
 	if u == nil || u.ID == 0 {
 		return errors.New("not a valid user account")
 	}
-	if impersonator == nil || impersonator.ID == 0 || !impersonator.IsAdmin {
+	if impersonator == nil || impersonator.ID == 0 || !impersonator.HasPermission(models.PermissionUsersImpersonate) {
 		return errors.New("impersonator not a valid admin account")
 	}
 
 	sess := Get(r)
+	unindex(sess.UserID, sess.UUID)
 	sess.LoggedIn = true
 	sess.UserID = u.ID
 	sess.Impersonator = impersonator.ID
+	sess.ImpersonateExpires = time.Now().Add(config.ImpersonateExpires)
 	sess.Save(w)
 
+	log.Warn("ImpersonateUser: admin %s is impersonating %s: %s", impersonator.Username, u.Username, reason)
+	sess.audit(u, models.AuditLog{
+		Action:        models.AuditImpersonate,
+		ActorID:       impersonator.ID,
+		ActorUsername: impersonator.Username,
+		Reason:        reason,
+	})
+
 	// Email the admins.
 	if err := mail.Send(mail.Message{
 		To:       config.Current.AdminEmail,
@@ -199,15 +272,59 @@ func ImpersonateUser(w http.ResponseWriter, r *http.Request, u *models.User, imp
 			"Impersonator": impersonator,
 			"User":         u,
 			"Reason":       reason,
-			"AdminURL":     config.Current.BaseURL + "/admin/feedback",
+			"AdminURL":     router.MustAbsoluteURLFor("admin"),
 		},
 	}); err != nil {
-		log.Error("/contact page: couldn't send email: %s", err)
+		log.Error("ImpersonateUser: couldn't send email: %s", err)
 	}
 
 	return u.Save()
 }
 
+// StopImpersonating returns an impersonating admin to their own account.
+func StopImpersonating(w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	return Get(r).EndImpersonation(w)
+}
+
+// EndImpersonation returns the session to the impersonating admin's account, returning the admin.
+func (s *Session) EndImpersonation(w http.ResponseWriter) (*models.User, error) {
+	if s.Impersonator == 0 {
+		return nil, errors.New("you are not impersonating a user")
+	}
+
+	// Record the end of it on the impersonated user's account.
+	var reason string
+	if s.ImpersonationExpired() {
+		reason = "time limit reached"
+	}
+	if user, err := models.GetUser(s.UserID); err == nil {
+		s.audit(user, models.AuditLog{
+			Action:  models.AuditUnimpersonate,
+			ActorID: s.Impersonator,
+			Reason:  reason,
+		})
+	}
+
+	admin, err := models.GetUser(s.Impersonator)
+	if err != nil {
+		// The admin account is gone: log out entirely.
+		s.LoggedIn = false
+		s.UserID = 0
+	} else {
+		s.UserID = admin.ID
+	}
+
+	s.Impersonator = 0
+	s.ImpersonateExpires = time.Time{}
+	s.Save(w)
+	return admin, err
+}
+
+// ImpersonationExpired checks if the admin's time impersonating a user on this session is up.
+func (s *Session) ImpersonationExpired() bool {
+	return s.Impersonator > 0 && time.Now().After(s.ImpersonateExpires)
+}
+
 // Impersonated returns if the current session has an impersonator.
 func Impersonated(r *http.Request) bool {
 	sess := Get(r)
@@ -221,7 +338,11 @@ func Impersonated(r *http.Request) bool {
 // LogoutUser signs a user out.
 func LogoutUser(w http.ResponseWriter, r *http.Request) {
 	sess := Get(r)
+	unindex(sess.UserID, sess.UUID)
 	sess.LoggedIn = false
 	sess.UserID = 0
+	sess.Impersonator = 0
+	sess.ImpersonateExpires = time.Time{}
+	sess.TwoFactorUserID = 0
 	sess.Save(w)
 }
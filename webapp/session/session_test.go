@@ -2,9 +2,13 @@ package session_test
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/redis"
 	"github.com/aichaos/silhouette/webapp/session"
 )
 
@@ -83,3 +87,44 @@ func TestRemoteAddr(t *testing.T) {
 		}
 	}
 }
+
+// Logins at the same time must all make it into the user's session index, so that signing out
+// everywhere gets them all.
+func TestConcurrentLogins(t *testing.T) {
+	const userID, logins = 7, 20
+
+	// The SQLite store is slow enough for the logins to overlap.
+	store, err := redis.NewSQLiteStore(filepath.Join(t.TempDir(), "cache.sqlite"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %s", err)
+	}
+	defer func(backend redis.Store) {
+		redis.Backend = backend
+		store.Close()
+	}(redis.Backend)
+	redis.Backend = store
+
+	var wg sync.WaitGroup
+	for i := 0; i < logins; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sess := session.New()
+			sess.LoggedIn = true
+			sess.UserID = userID
+			sess.Save(httptest.NewRecorder())
+		}()
+	}
+	wg.Wait()
+
+	if list, err := session.ListSessions(userID); err != nil || len(list) != logins {
+		t.Fatalf("ListSessions: expected %d sessions but got %d, %v", logins, len(list), err)
+	}
+
+	if err := session.RevokeAllSessions(userID, ""); err != nil {
+		t.Fatalf("RevokeAllSessions: %s", err)
+	}
+	if list, _ := session.ListSessions(userID); len(list) != 0 {
+		t.Errorf("ListSessions after RevokeAllSessions: expected none but got %d", len(list))
+	}
+}
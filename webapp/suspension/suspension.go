@@ -0,0 +1,120 @@
+// Package suspension suspends and reinstates user accounts, and lets the user know by email.
+package suspension
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/log"
+	"github.com/aichaos/silhouette/webapp/mail"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/router"
+	"github.com/aichaos/silhouette/webapp/session"
+)
+
+// Suspend a user account, sign them out everywhere and email them the reason and end date. Give a
+// zero expiresAt to suspend them until an admin lifts it.
+func Suspend(user, admin *models.User, reason string, expiresAt time.Time) (*models.Suspension, error) {
+	s, err := user.Suspend(admin, reason, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := session.RevokeAllSessions(user.ID, ""); err != nil {
+		log.Error("suspension.Suspend: couldn't revoke sessions for %s: %s", user.Username, err)
+	}
+
+	if err := mail.Send(mail.Message{
+		To:       user.Email,
+		Subject:  "Your account has been suspended",
+		Template: "email/suspended.html",
+		Data: map[string]interface{}{
+			"Title":      config.Title,
+			"Username":   user.Username,
+			"Reason":     s.Reason,
+			"Until":      Until(s),
+			"Indefinite": s.ExpiresAt.IsZero(),
+		},
+	}); err != nil {
+		log.Error("suspension.Suspend: couldn't email %s: %s", user.Username, err)
+	}
+
+	return s, nil
+}
+
+// Reinstate a suspended user account and email them that they can log in again.
+func Reinstate(user *models.User) error {
+	if err := user.Reinstate(); err != nil {
+		return err
+	}
+
+	if err := mail.Send(mail.Message{
+		To:       user.Email,
+		Subject:  "Your account has been reinstated",
+		Template: "email/reinstated.html",
+		Data: map[string]interface{}{
+			"Title":    config.Title,
+			"Username": user.Username,
+			"URL":      router.MustAbsoluteURLFor("login"),
+		},
+	}); err != nil {
+		log.Error("suspension.Reinstate: couldn't email %s: %s", user.Username, err)
+	}
+
+	return nil
+}
+
+// Check a user's suspension, reinstating them if it has run out. It returns the suspension still
+// in force, or nil when the user isn't (or is no longer) suspended.
+func Check(user *models.User) (*models.Suspension, error) {
+	if user.Status != models.UserStatusSuspended {
+		return nil, nil
+	}
+
+	s, err := models.GetSuspension(user.ID)
+	if err != nil {
+		// Suspended without the details: keep them out until an admin sorts it.
+		log.Error("suspension.Check: user %s is suspended but has no suspension: %s", user.Username, err)
+		return &models.Suspension{UserID: user.ID}, nil
+	}
+
+	if !s.Expired() {
+		return s, nil
+	}
+
+	if err := Reinstate(user); err != nil {
+		return s, err
+	}
+
+	if err := models.CreateAuditLog(&models.AuditLog{
+		Action:         models.AuditSuspensionExpired,
+		TargetID:       user.ID,
+		TargetUsername: user.Username,
+		Before:         models.UserStatusSuspended,
+		After:          string(user.Status),
+		Reason:         s.Reason,
+	}); err != nil {
+		log.Error("suspension.Check: couldn't record reinstatement of %s: %s", user.Username, err)
+	}
+
+	log.Info("suspension.Check: suspension of %s has expired; reinstated", user.Username)
+	return nil, nil
+}
+
+// Until describes when a suspension ends, for messages to the user.
+func Until(s *models.Suspension) string {
+	if s.ExpiresAt.IsZero() {
+		return "until further notice"
+	}
+	return "until " + s.ExpiresAt.Format("Jan _2 2006 15:04 MST")
+}
+
+// Message to show a suspended user who tries to log in.
+func Message(s *models.Suspension) string {
+	var message = fmt.Sprintf("Your account has been suspended %s.", Until(s))
+	if s.Reason != "" {
+		message += fmt.Sprintf(" The reason given was: %q.", s.Reason)
+	}
+	return message + " If you believe this was done in error, please contact support."
+}
@@ -14,6 +14,20 @@ var ForbiddenPage = func() http.HandlerFunc {
 	return MakeErrorPage("Forbidden", "You do not have permission for this page.", http.StatusForbidden)
 }()
 
+// PendingApprovalPage is an HTTP handler for members whose account awaits an admin's approval.
+var PendingApprovalPage = func() http.HandlerFunc {
+	return MakeErrorPage(
+		"Awaiting Approval",
+		"Thanks for signing up! Your account is waiting for an admin to approve it, and we will e-mail you when they do.",
+		http.StatusForbidden,
+	)
+}()
+
+// MethodNotAllowedPage is an HTTP handler for 405 pages.
+var MethodNotAllowedPage = func() http.HandlerFunc {
+	return MakeErrorPage("Method Not Allowed", "This page does not accept that kind of request.", http.StatusMethodNotAllowed)
+}()
+
 func MakeErrorPage(header string, message string, statusCode int) http.HandlerFunc {
 	tmpl := Must("errors/error.html")
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
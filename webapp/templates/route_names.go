@@ -0,0 +1,111 @@
+package templates
+
+import (
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template/parse"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	"github.com/aichaos/silhouette/webapp/router"
+)
+
+// Template functions that take a route name as their first argument.
+var routeNameFuncs = map[string]bool{
+	"URLFor":         true,
+	"AbsoluteURLFor": true,
+}
+
+// CheckRouteNames parses every template on disk and verifies that the route names they give
+// to URLFor are registered. Call it at startup, after the routes are set up, so a typo in a
+// template fails early rather than when the page is visited.
+func CheckRouteNames() error {
+	var used = map[string][]string{}
+	err := filepath.Walk(config.TemplatePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasSuffix(path, ".html") {
+			return err
+		}
+
+		filename, err := filepath.Rel(config.TemplatePath, path)
+		if err != nil {
+			return err
+		}
+		for _, name := range routeNamesInFile(path) {
+			used[name] = append(used[name], filename)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return router.CheckNames(used)
+}
+
+// routeNamesInFile returns the literal route names used in a template file. A file that
+// doesn't parse is skipped; that error surfaces when the template is loaded.
+func routeNamesInFile(path string) []string {
+	tmpl, err := template.New(filepath.Base(path)).Funcs(TemplateFuncs(nil)).ParseFiles(path)
+	if err != nil {
+		return nil
+	}
+
+	var names = []string{}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			names = append(names, routeNamesInNode(t.Tree.Root)...)
+		}
+	}
+	return names
+}
+
+// routeNamesInNode walks a template parse tree for calls to the route name funcs.
+func routeNamesInNode(node parse.Node) []string {
+	var names = []string{}
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			for _, child := range n.Nodes {
+				names = append(names, routeNamesInNode(child)...)
+			}
+		}
+	case *parse.ActionNode:
+		names = append(names, routeNamesInNode(n.Pipe)...)
+	case *parse.TemplateNode:
+		names = append(names, routeNamesInNode(n.Pipe)...)
+	case *parse.IfNode:
+		names = append(names, routeNamesInBranch(&n.BranchNode)...)
+	case *parse.RangeNode:
+		names = append(names, routeNamesInBranch(&n.BranchNode)...)
+	case *parse.WithNode:
+		names = append(names, routeNamesInBranch(&n.BranchNode)...)
+	case *parse.PipeNode:
+		if n != nil {
+			for _, cmd := range n.Cmds {
+				names = append(names, routeNamesInNode(cmd)...)
+			}
+		}
+	case *parse.CommandNode:
+		if len(n.Args) >= 2 {
+			if ident, ok := n.Args[0].(*parse.IdentifierNode); ok && routeNameFuncs[ident.Ident] {
+				if name, ok := n.Args[1].(*parse.StringNode); ok {
+					names = append(names, name.Text)
+				}
+			}
+		}
+		for _, arg := range n.Args {
+			names = append(names, routeNamesInNode(arg)...)
+		}
+	}
+	return names
+}
+
+func routeNamesInBranch(n *parse.BranchNode) []string {
+	var names = routeNamesInNode(n.Pipe)
+	names = append(names, routeNamesInNode(n.List)...)
+	if n.ElseList != nil {
+		names = append(names, routeNamesInNode(n.ElseList)...)
+	}
+	return names
+}
@@ -11,6 +11,9 @@ import (
 
 	"github.com/aichaos/silhouette/webapp/config"
 	"github.com/aichaos/silhouette/webapp/markdown"
+	"github.com/aichaos/silhouette/webapp/models"
+	"github.com/aichaos/silhouette/webapp/pow"
+	"github.com/aichaos/silhouette/webapp/router"
 	"github.com/aichaos/silhouette/webapp/session"
 	"github.com/aichaos/silhouette/webapp/utility"
 )
@@ -24,6 +27,9 @@ type Number interface {
 func TemplateFuncs(r *http.Request) template.FuncMap {
 	return template.FuncMap{
 		"InputCSRF":         InputCSRF(r),
+		"CSRFToken":         CSRFToken(r),
+		"ProofOfWork":       ProofOfWork,
+		"Can":               Can(r),
 		"SincePrettyCoarse": SincePrettyCoarse(),
 		"ComputeAge":        utility.Age,
 		"Split":             strings.Split,
@@ -39,6 +45,8 @@ func TemplateFuncs(r *http.Request) template.FuncMap {
 		"SubtractInt":       SubtractInt,
 		"UrlEncode":         UrlEncode,
 		"QueryPlus":         QueryPlus(r),
+		"URLFor":            router.URLFor,
+		"AbsoluteURLFor":    router.AbsoluteURLFor,
 	}
 }
 
@@ -58,6 +66,64 @@ func InputCSRF(r *http.Request) func() template.HTML {
 	}
 }
 
+// CSRFToken returns the raw CSRF token, e.g. for JavaScript to send in the X-CSRF-Token header.
+func CSRFToken(r *http.Request) func() string {
+	return func() string {
+		if r == nil {
+			return ""
+		}
+		if token, ok := r.Context().Value(session.CSRFKey).(string); ok {
+			return token
+		}
+		return ""
+	}
+}
+
+// ProofOfWork returns the HTML snippet for a form's proof of work challenge (checked by
+// middleware.ProofOfWork): hidden inputs and the script that solves it. Empty if disabled.
+func ProofOfWork() template.HTML {
+	if !pow.Enabled() {
+		return ""
+	}
+
+	challenge, err := pow.NewChallenge()
+	if err != nil {
+		return template.HTML(`[proof of work error]`)
+	}
+
+	return template.HTML(fmt.Sprintf(
+		`<input type="hidden" name="%s" value="%s" data-pow-difficulty="%d">`+
+			`<input type="hidden" name="%s" value="">`+
+			`<script type="text/javascript" src="/static/js/pow.js?build=%s"></script>`,
+		config.ProofOfWorkInputName,
+		challenge,
+		pow.Difficulty(challenge),
+		config.ProofOfWorkNonceInputName,
+		config.RuntimeBuild,
+	))
+}
+
+// Can checks if the current user's roles grant a permission, to hide what they can't use:
+// {{if Can "users.ban"}}.
+func Can(r *http.Request) func(string) bool {
+	var (
+		user   *models.User
+		looked bool
+	)
+	return func(permission string) bool {
+		if r == nil {
+			return false
+		}
+		if !looked {
+			looked = true
+			if u, err := session.CurrentUser(r); err == nil {
+				user = u
+			}
+		}
+		return user.HasPermission(permission)
+	}
+}
+
 // SincePrettyCoarse formats a time.Duration in plain English. Intended for "joined 2 months ago" type
 // strings - returns the coarsest level of granularity.
 func SincePrettyCoarse() func(time.Time) template.HTML {
@@ -121,7 +121,7 @@ func (t *Template) Reload() error {
 // Base template layout.
 var baseTemplates = []string{
 	config.TemplatePath + "/base.html",
-	// config.TemplatePath + "/partials/user_avatar.html",
+	config.TemplatePath + "/partials/user_avatar.html",
 	// mix in other partials here
 }
 
@@ -0,0 +1,122 @@
+// Package totp implements time-based one-time passwords (RFC 6238) for two-factor authentication.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/config"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Base32 encoding used for shared secrets (no padding, as authenticator apps expect).
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret creates a new random shared secret, base32 encoded.
+func GenerateSecret() (string, error) {
+	var buf = make([]byte, config.TwoFactorSecretSize)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(buf), nil
+}
+
+// Code computes the one-time password for the secret at the given time.
+func Code(secret string, at time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(at.Unix())/uint64(config.TwoFactorPeriod.Seconds())), nil
+}
+
+// Validate a one-time password against the secret, allowing for a little clock drift
+// between the server and the user's device.
+func Validate(code, secret string, at time.Time) bool {
+	_, ok := ValidateCounter(code, secret, at)
+	return ok
+}
+
+// ValidateCounter is like Validate, and also returns the time step the code was for, so the
+// caller can refuse to accept the same code twice.
+func ValidateCounter(code, secret string, at time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != config.TwoFactorDigits {
+		return 0, false
+	}
+
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	counter := int64(at.Unix()) / int64(config.TwoFactorPeriod.Seconds())
+	for i := -config.TwoFactorSkew; i <= config.TwoFactorSkew; i++ {
+		expect := hotp(key, uint64(counter+int64(i)))
+		if subtle.ConstantTimeCompare([]byte(code), []byte(expect)) == 1 {
+			return counter + int64(i), true
+		}
+	}
+	return 0, false
+}
+
+// URI returns the otpauth:// URI that authenticator apps can import.
+func URI(account, secret string) string {
+	var (
+		label  = url.PathEscape(config.Title + ":" + account)
+		params = url.Values{}
+	)
+	params.Set("secret", secret)
+	params.Set("issuer", config.Title)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", config.TwoFactorDigits))
+	params.Set("period", fmt.Sprintf("%d", int(config.TwoFactorPeriod.Seconds())))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// QRCode renders the otpauth URI as a PNG image and returns it as a data: URI
+// suitable for an <img src> attribute.
+func QRCode(uri string) (string, error) {
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
+
+// decodeSecret parses a base32 secret, tolerating lowercase and spaces.
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	secret = strings.TrimRight(secret, "=")
+	return encoding.DecodeString(secret)
+}
+
+// hotp computes the HMAC-based one-time password (RFC 4226) for a counter value.
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation.
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	var mod uint32 = 1
+	for i := 0; i < config.TwoFactorDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", config.TwoFactorDigits, value%mod)
+}
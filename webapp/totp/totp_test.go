@@ -0,0 +1,82 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/aichaos/silhouette/webapp/totp"
+)
+
+func TestCode(t *testing.T) {
+	// RFC 6238 Appendix B test vectors (SHA1), truncated to 6 digits.
+	var secret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	var tests = []struct {
+		Unix   int64
+		Expect string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, test := range tests {
+		actual, err := totp.Code(secret, time.Unix(test.Unix, 0))
+		if err != nil {
+			t.Errorf("Code(%d) error: %s", test.Unix, err)
+			continue
+		}
+		if actual != test.Expect {
+			t.Errorf("Code(%d) expected %s but got %s", test.Unix, test.Expect, actual)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %s", err)
+	}
+
+	var now = time.Unix(1700000000, 0)
+	code, _ := totp.Code(secret, now)
+
+	var tests = []struct {
+		Code   string
+		At     time.Time
+		Expect bool
+	}{
+		{code, now, true},
+		{code, now.Add(30 * time.Second), true},  // within skew
+		{code, now.Add(-30 * time.Second), true}, // within skew
+		{code, now.Add(5 * time.Minute), false},  // too late
+		{code[:3] + " " + code[3:], now, true},   // user typed a space
+		{"", now, false},
+		{"12345", now, false},
+	}
+
+	for _, test := range tests {
+		if actual := totp.Validate(test.Code, secret, test.At); actual != test.Expect {
+			t.Errorf("Validate(%q, %s) expected %v but got %v", test.Code, test.At, test.Expect, actual)
+		}
+	}
+}
+
+func TestValidateCounter(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %s", err)
+	}
+
+	var now = time.Unix(1700000000, 0)
+	code, _ := totp.Code(secret, now)
+
+	// The counter is the code's time step, even when it's checked a step later.
+	if counter, ok := totp.ValidateCounter(code, secret, now.Add(30*time.Second)); !ok || counter != 1700000000/30 {
+		t.Errorf("ValidateCounter: expected step %d but got %d, %v", 1700000000/30, counter, ok)
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"net/http"
 
 	"github.com/aichaos/silhouette/webapp/log"
-	"github.com/aichaos/silhouette/webapp/router"
+	"github.com/aichaos/silhouette/webapp/purge"
+	"github.com/aichaos/silhouette/webapp/routes"
+	"github.com/aichaos/silhouette/webapp/templates"
 )
 
 // WebServer is the main entry point for the `webapp web` command.
@@ -25,9 +27,19 @@ func (ws *WebServer) Run() error {
 		ws.Port = 8080
 	}
 
+	handler := routes.New()
+
+	// Purge accounts whose deletion grace period has run out.
+	purge.Start()
+
+	// Catch templates linking to routes that don't exist.
+	if err := templates.CheckRouteNames(); err != nil {
+		return err
+	}
+
 	s := http.Server{
 		Addr:    fmt.Sprintf("%s:%d", ws.Host, ws.Port),
-		Handler: router.New(),
+		Handler: handler,
 	}
 
 	log.Info("Listening at http://%s:%d", ws.Host, ws.Port)